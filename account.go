@@ -4,116 +4,410 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"time"
 )
 
-const BidFactor = 0.9999
-const AskFactor = 1.0001
-
 type Account struct {
-	positions   *Positions
-	unboundCash float64
+	primary     *Holding   // 戦略が主に取引する銘柄
+	holdings    []*Holding // primary 以外の銘柄
+	liquidation LiquidationPolicy
+	currency    string  // 口座の通貨。空なら銘柄の通貨を区別しない
+	unboundCash float64 // 口座通貨
+	tradeCosts  TradeCosts
+	date        time.Time
+	losscuts    []*LosscutEvent
+	fills       []*FillEvent // DrainFills で取り出すまでの約定
+	marginCall  *MarginCall  // 期限前の追証。なければ nil
+	marginCalls []*MarginCallEvent
+
+	// primary のもの
+	positions *Positions // 買いポジション
+	shorts    *Positions // 売りポジション
+	cost      CostModel
+}
+
+// 取引で支払ったコストの累計
+type TradeCosts struct {
+	Spread     float64 // 仲値と約定値の差のうち、スプレッドによるもの
+	Slippage   float64 // 仲値と約定値の差のうち、スリッページによるもの
+	Commission float64
 }
 
+// primary の銘柄の名前
+const primaryInstrument = "index"
+
 func NewAccount() *Account {
-	return &Account{
-		positions:   NewPositions(),
+	return NewAccountWithCostModel(DefaultCostModel())
+}
+
+func NewAccountWithCostModel(c CostModel) *Account {
+	return NewAccountWithInstrument(NewIndexInstrument(primaryInstrument, c))
+}
+
+// in を primary の銘柄とする口座
+func NewAccountWithInstrument(in *Instrument) *Account {
+	a := &Account{
+		liquidation: LiquidateAll,
 		unboundCash: 0.0,
 	}
+	a.primary = newHolding(a, in)
+	a.positions = a.primary.longs
+	a.shorts = a.primary.shorts
+	a.cost = a.primary.cost
+	return a
+}
+
+// 銘柄を追加する
+// 現金と証拠金は既存の銘柄と共有する
+func (a *Account) AddInstrument(in *Instrument) (*Holding, error) {
+	if a.Holding(in.Name) != nil {
+		return nil, fmt.Errorf("duplicate instrument: %s", in.Name)
+	}
+	h := newHolding(a, in)
+	h.book = a.primary.book
+	a.holdings = append(a.holdings, h)
+	return h, nil
+}
+
+// primary の銘柄の建玉
+func (a *Account) Primary() *Holding {
+	return a.primary
+}
+
+// 名前を指定して銘柄の建玉を返す。なければ nil
+func (a *Account) Holding(name string) *Holding {
+	for _, h := range a.Holdings() {
+		if h.instrument.Name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// primary を先頭にした、すべての銘柄の建玉
+func (a *Account) Holdings() []*Holding {
+	return append([]*Holding{a.primary}, a.holdings...)
+}
+
+// 口座の通貨を返す
+func (a *Account) Currency() string {
+	return a.currency
+}
+
+// 口座の通貨を設定する
+// 入出金、評価額、証拠金はすべてこの通貨で扱い、通貨の違う銘柄は為替レートで換算する
+func (a *Account) SetCurrency(c string) {
+	a.currency = c
+}
+
+// 追証のときの決済の順番を設定する
+func (a *Account) SetLiquidationPolicy(p LiquidationPolicy) {
+	a.liquidation = p
+}
+
+// 買いと売りのポジションの持ち方
+type BookMode string
+
+const (
+	// 買いと売りを同時に持てる (両建て)
+	BookHedged BookMode = "hedged"
+	// 反対のポジションがあれば、新規で建てる代わりにそれを決済する
+	BookNet BookMode = "net"
+)
+
+func ParseBookMode(s string) (BookMode, error) {
+	switch m := BookMode(s); m {
+	case BookHedged, BookNet:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown book mode: %s", s)
+}
+
+// ポジションの持ち方を返す
+func (a *Account) BookMode() BookMode {
+	return a.primary.book
+}
+
+// すべての銘柄のポジションの持ち方を設定する
+func (a *Account) SetBookMode(m BookMode) {
+	for _, h := range a.Holdings() {
+		h.book = m
+	}
+}
+
+// 取引コストのモデルを返す
+func (a *Account) CostModel() CostModel {
+	return a.cost
 }
 
-// ポジションの列を返す
+// これまでに支払った取引コストの累計
+func (a *Account) TradeCosts() TradeCosts {
+	return a.tradeCosts
+}
+
+// 成行で数量 q だけ買うときの約定値
+// 手数料を支払い、コストを記録する
+func (a *Account) buy(current float64, q float64) float64 {
+	return a.primary.buy(current, q)
+}
+
+// 成行で数量 q だけ売るときの約定値
+// 手数料を支払い、コストを記録する
+func (a *Account) sell(current float64, q float64) float64 {
+	return a.primary.sell(current, q)
+}
+
+// 買いポジションの列を返す
 func (a *Account) Positions() *Positions {
 	return a.positions
 }
 
+// 売りポジションの列を返す
+func (a *Account) Shorts() *Positions {
+	return a.shorts
+}
+
+// すべての銘柄の、買いと売りを合わせたポジションの数
+func (a *Account) PositionCount() int {
+	n := 0
+	for _, h := range a.Holdings() {
+		n += h.PositionCount()
+	}
+	return n
+}
+
+// すべての銘柄の、買いと売りを合わせた必要証拠金
+func (a *Account) RequiredMargin() float64 {
+	m := a.primary.RequiredMargin()
+	for _, h := range a.holdings {
+		m += h.RequiredMargin()
+	}
+	return m
+}
+
 // 口座に入金
 func (a *Account) Deposit(c float64) {
 	a.unboundCash += c
 }
 
 // 余力
+// primary の銘柄は current で、ほかの銘柄は評価用の仲値で評価する
 func (a *Account) Remaining(current float64) float64 {
-	return a.unboundCash - a.positions.ValuationLoss(current*BidFactor)
+	return a.remainingWith(a.primary, current)
+}
+
+// 銘柄 h を current で、ほかの銘柄を評価用の仲値で評価したときの余力
+func (a *Account) remainingWith(h *Holding, current float64) float64 {
+	if len(a.holdings) == 0 && a.shorts.Size() == 0 && !a.primary.foreign() {
+		return a.unboundCash - a.positions.ValuationLoss(a.cost.Bid(current))
+	}
+	// 買いと売り、銘柄同士の評価損益は打ち消し合う
+	profit := 0.0
+	for _, o := range a.Holdings() {
+		profit += o.profit(a.priceOf(o, h, current))
+	}
+	return a.unboundCash - math.Max(0, -profit)
+}
+
+// o が h なら current、そうでなければ o の評価用の仲値
+func (a *Account) priceOf(o, h *Holding, current float64) float64 {
+	if o == h {
+		return current
+	}
+	return o.mark
 }
 
 // 口座の評価額
+// 買いポジションは売値、売りポジションは買値で評価する
+// primary の銘柄は current で、ほかの銘柄は評価用の仲値で評価する
 func (a *Account) Valuation(current float64) float64 {
-	return a.unboundCash + a.positions.Valuation(current*BidFactor)
+	v := a.unboundCash + a.primary.valuation(current)
+	for _, h := range a.holdings {
+		v += h.valuation(h.mark)
+	}
+	return v
 }
 
 // 口座の実効レバレッジ
+// すべての銘柄の、買いと売りの建玉の時価の合計を使う
 func (a *Account) Leverage(current float64) float64 {
 	// Bidでいいのか微妙
-	return current * BidFactor * float64(a.positions.Size()) / a.Valuation(current)
-}
-
-// 指定した値以上のロスカット値のポジションをロスカット
-// そのポジションに設定されているロスカット値で決済する
-// スリップはしない
-func (a *Account) ExecLosscut(low float64) {
-	// 建単価の順とロスカット値の順は別だが、一旦これで
-	for a.positions.Max() != nil {
-		p := a.positions.Max()
-		lv := p.LosscutValue()
-		if lv >= low*BidFactor {
-			a.positions.RemoveMax()
-			a.unboundCash += p.Valuation(lv)
-			log.Printf("Losscut!: losscut_value=%f, position=%v", lv, p)
-			continue
-		}
-		break
+	exposure := 0.0
+	for _, h := range a.Holdings() {
+		price := a.priceOf(h, a.primary, current)
+		m := h.instrument.Spec.Multiplier
+		exposure += h.toAccount((h.cost.Bid(price)*h.longs.Quantity() + h.cost.Ask(price)*h.shorts.Quantity()) * m)
 	}
+	return exposure / a.Valuation(current)
+}
+
+// primary の銘柄のロスカット
+// 価格 current の売値以上のロスカット値の買いポジションと、
+// 買値以下のロスカット値の売りポジションをロスカット
+// 始値 open の時点でロスカット値を越えていた (窓を開けた) 場合は、始値から決済する
+func (a *Account) ExecLosscut(open float64, current float64) {
+	a.primary.ExecLosscut(open, current)
 }
 
-// 余力があれば、現在値でポジションをひとつ建てる
+// ロスカットされたときの記録
+type LosscutEvent struct {
+	Date         time.Time
+	Instrument   string
+	Side         Side
+	Unit         float64 // 建単価
+	LosscutValue float64
+	Fill         float64 // 約定値
+	Gap          bool    // ロスカット値より不利な値で約定したかどうか
+}
+
+// ロスカット値と約定値の差。不利な方向に滑ると正
+func (e *LosscutEvent) Slippage() float64 {
+	return (e.LosscutValue - e.Fill) * e.Side.sign()
+}
+
+// これまでのロスカットの記録
+func (a *Account) LosscutEvents() []*LosscutEvent {
+	return a.losscuts
+}
+
+// 成行注文の約定の記録
+// ロスカットによる決済は LosscutEvent に記録する
+type FillEvent struct {
+	Date       time.Time
+	Instrument string
+	Side       Side    // Long なら買い、Short なら売り
+	Quantity   float64 // 数量
+	Price      float64 // 約定値
+}
+
+// 前回取り出してからの約定を返し、記録を空にする
+// 約定は数が多いので、口座には溜めておかない
+func (a *Account) DrainFills() []*FillEvent {
+	fs := a.fills
+	a.fills = nil
+	return fs
+}
+
+// 現在の日付を設定する。記録に使う
+func (a *Account) SetDate(t time.Time) {
+	a.date = t
+}
+
+// 余力があれば、現在値で買いポジションをひとつ建てる
 // ポジションのロスカット値は lv に指定する
+// net なら、売りポジションがあれば代わりにそれをひとつ決済する
 func (a *Account) Open(current float64, lv float64) {
-	if a.CanOpen(current, lv) {
-		p := NewPosition(current * AskFactor)
-		p.SetLosscutValue(lv)
-		a.positions.Add(p)
-		a.unboundCash -= p.BoundMargin()
-		if a.unboundCash < 0 {
-			panic("unbound cash < 0")
-		}
-	}
+	a.primary.Open(current, lv)
+}
+
+// 余力があれば、現在値で数量 q の買いポジションをひとつ建てる
+// 数量は取引単位に切り捨て、建てた数量を返す
+func (a *Account) OpenQuantity(current float64, lv float64, q float64) float64 {
+	return a.primary.OpenQuantity(current, lv, q)
+}
+
+// 余力があれば、現在値で約定代金 amount の買いポジションをひとつ建てる
+// 数量は取引単位に切り捨て、建てた数量を返す
+func (a *Account) OpenAmount(current float64, lv float64, amount float64) float64 {
+	spec := a.primary.instrument.Spec
+	return a.OpenQuantity(current, lv, amount/(a.primary.buyPrice(current)*spec.Multiplier))
+}
+
+// 余力で建てられる買いポジションの最大の数量。取引単位に切り捨てる
+func (a *Account) MaxOpenQuantity(current float64, lv float64) float64 {
+	return a.primary.MaxOpenQuantity(Long, current, lv)
+}
+
+// Open, OpenShort などで使う注文の大きさを設定する
+func (a *Account) SetOrderSize(o OrderSize) {
+	a.primary.SetOrderSize(o)
 }
 
 // 余力があるだけ、現在値でポジションを建てる
 // 建てた数を返す
 // ポジションのロスカット値は lv に指定する
+// 注文の大きさに満たない余力が残れば、取引単位で建てられるだけ建てる
 func (a *Account) FullOpen(current float64, lv float64) int {
 	n := 0
 	for a.CanOpen(current, lv) {
 		a.Open(current, lv)
 		n++
 	}
+	if a.OpenQuantity(current, lv, a.MaxOpenQuantity(current, lv)) != 0 {
+		n++
+	}
 	return n
 }
 
 // ポジションを建てる余力があるかどうか確認
 func (a *Account) CanOpen(current float64, lv float64) bool {
-	p := NewPosition(current * AskFactor)
-	p.SetLosscutValue(lv)
-	return a.Remaining(current) >= p.BoundMargin()
+	return a.primary.CanOpen(current, lv)
+}
+
+// 余力があれば、現在値で売りポジションをひとつ建てる
+// ポジションのロスカット値は lv に指定する
+// net なら、買いポジションがあれば代わりにそれをひとつ決済する
+func (a *Account) OpenShort(current float64, lv float64) {
+	a.primary.OpenShort(current, lv)
+}
+
+// 余力があるだけ、現在値で売りポジションを建てる
+// 建てた数を返す
+// 注文の大きさに満たない余力が残れば、取引単位で建てられるだけ建てる
+func (a *Account) FullOpenShort(current float64, lv float64) int {
+	n := 0
+	for a.CanOpenShort(current, lv) {
+		a.OpenShort(current, lv)
+		n++
+	}
+	q := a.primary.MaxOpenQuantity(Short, current, lv)
+	if a.primary.OpenShortQuantity(current, lv, q) != 0 {
+		n++
+	}
+	return n
+}
+
+// 売りポジションを建てる余力があるかどうか確認
+func (a *Account) CanOpenShort(current float64, lv float64) bool {
+	return a.primary.CanOpenShort(current, lv)
+}
+
+// 建単価が最小の売りポジションを決済
+// 売りポジションの中で最も評価損が大きい
+func (a *Account) CloseShortMin(current float64) {
+	a.primary.CloseShortMin(current)
+}
+
+// 建単価が最大の売りポジションを決済
+func (a *Account) CloseShortMax(current float64) {
+	a.primary.CloseShortMax(current)
+}
+
+// すべての銘柄から、評価損が最も大きいポジションを決済する
+func (a *Account) closeWorst(current float64) {
+	a.closeNext(LiquidateWorstFirst, current)
+}
+
+// 方針に従ってポジションをひとつ決済する
+// primary の銘柄は current で、ほかの銘柄は評価用の仲値で評価する
+func (a *Account) closeNext(policy LiquidationPolicy, current float64) {
+	prices := map[*Holding]float64{}
+	for _, h := range a.Holdings() {
+		prices[h] = a.priceOf(h, a.primary, current)
+	}
+	if c := policy.choose(liquidationCandidates(a.Holdings(), prices)); c != nil {
+		c.close(prices[c.holding])
+	}
 }
 
 // 建単価が最大のポジションを決済
 func (a *Account) CloseMax(current float64) {
-	p := a.positions.Max()
-	if p != nil {
-		a.unboundCash += p.Valuation(current * BidFactor)
-		a.positions.RemoveMax()
-	}
+	a.primary.CloseMax(current)
 }
 
 // 建単価が最大のポジションを決済
 func (a *Account) CloseMin(current float64) {
-	p := a.positions.Min()
-	if p != nil {
-		a.unboundCash += p.Valuation(current * BidFactor)
-		a.positions.RemoveMin()
-	}
+	a.primary.CloseMin(current)
 }
 
 // 持っているすべてのポジションのロスカット値を変更する
@@ -125,7 +419,7 @@ func (a *Account) SetLosscutValueWithClose(current float64, lv float64) {
 	for i != nil {
 		m := i.position.AdditionalMarginToLosscutValue(lv)
 		r := a.Remaining(current)
-		for r < m {
+		for r < a.primary.toAccount(m) {
 			// 余力が足りない場合は足りるまで決済していく
 			a.CloseMax(current)
 			r = a.Remaining(current)
@@ -137,17 +431,19 @@ func (a *Account) SetLosscutValueWithClose(current float64, lv float64) {
 		}
 
 		i.position.SetLosscutValue(lv)
-		a.unboundCash -= m
+		a.primary.bindMargin(m)
 
 		i = i.next
 		id++
 	}
 }
 
-// 持っている建玉をすべて決済する
+// すべての銘柄の、持っている建玉をすべて決済する
+// primary の銘柄は current で、ほかの銘柄は評価用の仲値で決済する
 func (a *Account) CloseAll(current float64) {
-	for a.Positions().Size() != 0 {
-		a.CloseMax(current)
+	a.primary.CloseAll(current)
+	for _, h := range a.holdings {
+		h.CloseAll(h.mark)
 	}
 }
 
@@ -155,6 +451,7 @@ func (a *Account) Dump(current float64) {
 	fmt.Printf("current: %f\n", current)
 	fmt.Printf("valuation: %f\n", a.Valuation(current))
 	fmt.Printf("count: %d\n", a.Positions().Size())
+	fmt.Printf("short count: %d\n", a.Shorts().Size())
 	fmt.Printf("unbound cash: %f\n", a.unboundCash)
 	/*
 		fmt.Printf("positions:\n")
@@ -163,7 +460,7 @@ func (a *Account) Dump(current float64) {
 			fmt.Printf(
 				"  unit: %f, valuation: %f, losscut value: %f, bound margin: %f\n",
 				i.position.unit,
-				i.position.Valuation(current*BidFactor),
+				i.position.Valuation(a.cost.Bid(current)),
 				i.position.LosscutValue(),
 				i.position.BoundMargin(),
 			)
@@ -182,7 +479,7 @@ func (a *Account) SetLeverageWithClose(current float64, l float64) {
 	for i != nil {
 		m := i.position.AdditionalMarginToLeverage(l)
 		r := a.Remaining(current)
-		for r < m {
+		for r < a.primary.toAccount(m) {
 			// 余力が足りない場合は足りるまで決済していく
 			a.CloseMin(current)
 			r = a.Remaining(current)
@@ -194,7 +491,7 @@ func (a *Account) SetLeverageWithClose(current float64, l float64) {
 		}
 
 		i.position.SetLeverage(l)
-		a.unboundCash -= m
+		a.primary.bindMargin(m)
 
 		i = i.prev
 		id++
@@ -204,16 +501,15 @@ func (a *Account) SetLeverageWithClose(current float64, l float64) {
 // 余力があるだけ、現在値でポジションを建てる
 // 建てた数を返す
 // ポジションのレバレッジはlに指定する
+// 注文の大きさに満たない余力が残れば、取引単位で建てられるだけ建てる
 func (a *Account) FullOpenWithLeverage(current float64, l float64) int {
 	n := 0
 	for a.CanOpenWithLeverage(current, l) {
-		p := NewPosition(current * AskFactor)
-		p.SetLeverage(l)
-		a.positions.Add(p)
-		a.unboundCash -= p.BoundMargin()
-		if a.unboundCash < 0 {
-			panic("unbound cash < 0")
-		}
+		a.openWithLeverage(current, l, a.primary.orderQuantity(a.primary.buyPrice(current)))
+		n++
+	}
+	if q := a.primary.affordable(a.Remaining(current), a.leverageOpenCost(current, l)); q != 0 {
+		a.openWithLeverage(current, l, q)
 		n++
 	}
 	return n
@@ -221,21 +517,166 @@ func (a *Account) FullOpenWithLeverage(current float64, l float64) int {
 
 // ポジションを建てる余力があるかどうか確認
 func (a *Account) CanOpenWithLeverage(current float64, l float64) bool {
-	p := NewPosition(current * AskFactor)
+	q := a.primary.orderQuantity(a.primary.buyPrice(current))
+	return q != 0 && a.Remaining(current) >= a.leverageOpenCost(current, l)(q)
+}
+
+// レバレッジ l で数量 q の買いポジションを建てるのに必要な額 (拘束証拠金と手数料)
+func (a *Account) leverageOpenCost(current float64, l float64) func(q float64) float64 {
+	return func(q float64) float64 {
+		p := a.primary.newPosition(Long, a.primary.buyPrice(current), q)
+		p.SetLeverage(l)
+		return a.primary.toAccount(p.BoundMargin()) + a.primary.commission(p.Unit(), q)
+	}
+}
+
+// 現在値でレバレッジ l の数量 q の買いポジションを建てる
+func (a *Account) openWithLeverage(current float64, l float64, q float64) {
+	p := a.primary.newPosition(Long, a.buy(current, q), q)
 	p.SetLeverage(l)
-	return a.Remaining(current) >= p.BoundMargin()
+	a.positions.Add(p)
+	a.primary.bind(p)
 }
 
 // 追証の処理
-// 口座の評価額が必要証拠金の額を割ると全建玉を強制決済する
-// (実際は株価指数CFDや商品CFDで評価額が変わるがひとつしかないものとする)
+// 口座全体の評価額が必要証拠金の額を割ると、決済の方針に従って強制決済する
+// primary の銘柄は low で、ほかの銘柄は評価用の仲値で評価する
 func (a *Account) ExecMarginCall(low float64) {
-	m := a.positions.RequiredMargin()
+	m := a.RequiredMargin()
 	v := a.Valuation(low)
 	if v < m {
-		a.CloseAll(low)
-		log.Printf("Margin call executed: %f", low)
+		a.liquidate(low)
+		a.recordMarginCall(MarginCallForced, v, m, time.Time{})
+	}
+}
+
+// 決済の方針に従って強制決済する
+func (a *Account) liquidate(current float64) {
+	if a.liquidation == LiquidateAll {
+		a.CloseAll(current)
+		return
 	}
+	for a.PositionCount() != 0 && a.MarginShortfall(current) > 0 {
+		a.closeNext(a.liquidation, current)
+	}
+}
+
+// 追証の扱い
+type MarginCallMode string
+
+const (
+	// 安値などで評価額が必要証拠金を割った時点で全建玉を強制決済する
+	MarginCallImmediate MarginCallMode = "immediate"
+	// 判定時刻 (終値) に追証を発生させ、期限までに解消されなければ強制決済する
+	MarginCallDeadline MarginCallMode = "deadline"
+)
+
+func ParseMarginCallMode(s string) (MarginCallMode, error) {
+	switch m := MarginCallMode(s); m {
+	case MarginCallImmediate, MarginCallDeadline:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown margin call mode: %s", s)
+}
+
+// 追証の状態
+type MarginCallState string
+
+const (
+	// 発生して、期限までに解消されるのを待っている
+	MarginCallPending MarginCallState = "pending"
+	// 入金や決済で解消された
+	MarginCallResolved MarginCallState = "resolved"
+	// 解消されずに強制決済された
+	MarginCallForced MarginCallState = "forced"
+)
+
+// 期限前の追証
+type MarginCall struct {
+	Issued   time.Time
+	Deadline time.Time // この日になっても解消されていなければ強制決済する
+	Amount   float64   // 発生時の不足額
+}
+
+// 追証の状態が変わったときの記録
+type MarginCallEvent struct {
+	Date      time.Time
+	State     MarginCallState
+	Valuation float64
+	Required  float64
+	Deadline  time.Time // pending のときだけ
+}
+
+// 不足額
+func (e *MarginCallEvent) Shortfall() float64 {
+	return math.Max(0, e.Required-e.Valuation)
+}
+
+func (a *Account) recordMarginCall(s MarginCallState, v, m float64, deadline time.Time) {
+	e := &MarginCallEvent{Date: a.date, State: s, Valuation: v, Required: m, Deadline: deadline}
+	a.marginCalls = append(a.marginCalls, e)
+	log.Printf("Margin call %s: valuation=%f, required=%f", s, v, m)
+}
+
+// 期限前の追証。なければ nil
+func (a *Account) MarginCall() *MarginCall {
+	return a.marginCall
+}
+
+// これまでの追証の記録
+func (a *Account) MarginCallEvents() []*MarginCallEvent {
+	return a.marginCalls
+}
+
+// 現在値で必要証拠金に足りない額。足りていれば0
+func (a *Account) MarginShortfall(current float64) float64 {
+	return math.Max(0, a.RequiredMargin()-a.Valuation(current))
+}
+
+// 判定時刻の追証の確認
+// 口座の評価額が必要証拠金の額を割っていれば、期限 deadline の追証を発生させる
+// すでに期限前の追証があれば何もしない
+func (a *Account) IssueMarginCall(current float64, deadline time.Time) {
+	if a.marginCall != nil {
+		return
+	}
+	m := a.RequiredMargin()
+	v := a.Valuation(current)
+	if v < m {
+		a.marginCall = &MarginCall{Issued: a.date, Deadline: deadline, Amount: m - v}
+		a.recordMarginCall(MarginCallPending, v, m, deadline)
+	}
+}
+
+// 期限前の追証の精算
+// 評価額が必要証拠金の額に戻っていれば解消し、期限になっても戻っていなければ強制決済する
+func (a *Account) SettleMarginCall(current float64) {
+	c := a.marginCall
+	if c == nil {
+		return
+	}
+	m := a.RequiredMargin()
+	v := a.Valuation(current)
+	switch {
+	case v >= m:
+		a.marginCall = nil
+		a.recordMarginCall(MarginCallResolved, v, m, time.Time{})
+	case !a.date.Before(c.Deadline):
+		a.marginCall = nil
+		a.liquidate(current)
+		a.recordMarginCall(MarginCallForced, v, m, time.Time{})
+	}
+}
+
+// 評価額が必要証拠金の額に戻るまで、評価損が最も大きいポジションから決済する
+// 決済した数を返す
+func (a *Account) ReduceForMarginCall(current float64) int {
+	n := 0
+	for a.PositionCount() != 0 && a.MarginShortfall(current) > 0 {
+		a.closeWorst(current)
+		n++
+	}
+	return n
 }
 
 // 2 series
@@ -246,8 +687,8 @@ func (a *Account) ExecMarginCall(low float64) {
 // レバレッジ0~1倍の場合、レバレッジ1倍の建玉を、余力のうち指定レバレッジ倍だけ買う
 func (a *Account) SetLeverageWithClose2(current float64, l float64) {
 	// ポジションが多い場合は先に決済しておく
-	num := int(a.Valuation(current) * math.Max(l, 0) / (current * AskFactor))
-	for a.positions.Size() > num {
+	num := a.primary.toQuote(a.Valuation(current)) * math.Max(l, 0) / (a.cost.Ask(current) * a.primary.instrument.Spec.Multiplier)
+	for a.positions.Quantity() > a.primary.instrument.Spec.RoundLot(num) {
 		a.CloseMin(current)
 	}
 	// TODO: maxItem とか next とかは positions.go に閉じ込める
@@ -259,7 +700,7 @@ func (a *Account) SetLeverageWithClose2(current float64, l float64) {
 		if l < 1 {
 			r = math.Max(r, 0) * l
 		}
-		for r < m {
+		for r < a.primary.toAccount(m) {
 			// 余力が足りない場合は足りるまで決済していく
 			a.CloseMin(current)
 			r = a.Remaining(current)
@@ -274,7 +715,7 @@ func (a *Account) SetLeverageWithClose2(current float64, l float64) {
 		}
 
 		i.position.SetLeverage(l)
-		a.unboundCash -= m
+		a.primary.bindMargin(m)
 		if a.unboundCash < 0 {
 			panic("unbound cash < 0")
 		}
@@ -287,16 +728,15 @@ func (a *Account) SetLeverageWithClose2(current float64, l float64) {
 // 余力があるだけ、現在値でポジションを建てる
 // 建てた数を返す
 // ポジションのレバレッジはlに指定する
+// 注文の大きさに満たない余力が残れば、取引単位で建てられるだけ建てる
 func (a *Account) FullOpenWithLeverage2(current float64, l float64) int {
 	n := 0
 	for a.CanOpenWithLeverage2(current, l) {
-		p := NewPosition(current * AskFactor)
-		p.SetLeverage(l)
-		a.positions.Add(p)
-		a.unboundCash -= p.BoundMargin()
-		if a.unboundCash < 0 {
-			panic("unbound cash < 0")
-		}
+		a.openWithLeverage(current, l, a.primary.orderQuantity(a.primary.buyPrice(current)))
+		n++
+	}
+	if q := a.primary.affordable(a.leverageBudget2(current, l), a.leverageOpenCost(current, l)); q != 0 {
+		a.openWithLeverage(current, l, q)
 		n++
 	}
 	return n
@@ -304,47 +744,112 @@ func (a *Account) FullOpenWithLeverage2(current float64, l float64) int {
 
 // ポジションを建てる余力があるかどうか確認
 func (a *Account) CanOpenWithLeverage2(current float64, l float64) bool {
-	p := NewPosition(current * AskFactor)
-	p.SetLeverage(l)
+	q := a.primary.orderQuantity(a.primary.buyPrice(current))
+	return q != 0 && a.leverageBudget2(current, l) >= a.leverageOpenCost(current, l)(q)
+}
+
+// レバレッジ l で建てるのに使える余力
+// レバレッジ0~1倍の場合は、余力のうち指定レバレッジ倍だけ使う
+func (a *Account) leverageBudget2(current float64, l float64) float64 {
 	r := a.Remaining(current)
 	if l < 1 {
 		r = math.Max(r, 0) * l
 	}
-	return r >= p.BoundMargin()
+	return r
 }
 
 // 口座全体の実効レバレッジを指定した値に調整する
 // ロスカットレートはできるだけすべて同一にする
-func (a *Account) SetLeverage(current float64, l float64) {
-	l = math.Min(math.Max(l, 0), 10)
-	// 実効レバレッジ = 現在値 * 建玉数 / 時価評価総額
-	// 建玉数 = 実効レバレッジ * 時価評価総額 / 現在値
-	size := int(l * a.Valuation(current) / (current * BidFactor))
-	a.FixPositionSize(current, size)
-	if a.Positions().Size() != size {
-		log.Fatalf("SetLeverage: position size mismatch")
+// 指定したレバレッジに届かなかった場合は、その理由を結果に含める
+func (a *Account) SetLeverage(current float64, l float64) *RebalanceResult {
+	spec := a.primary.instrument.Spec
+	l = math.Min(math.Max(l, 0), spec.MaxLeverage)
+	// 実効レバレッジ = 約定代金 * 数量 / 時価評価総額
+	// 数量 = 実効レバレッジ * 時価評価総額 / 約定代金
+	q := math.Max(0, l*a.primary.toQuote(a.Valuation(current))/(a.cost.Bid(current)*spec.Multiplier))
+	return a.FixPositionSize(current, q)
+}
+
+// 建玉の数量の調整結果
+type RebalanceResult struct {
+	Target       float64 // 目標の数量 (取引単位に切り捨てたもの)
+	Size         float64 // 調整後の数量
+	Opened       int     // 新規で建てた数
+	Closed       int     // 決済した数
+	LosscutValue float64 // 調整後のロスカット値 (最大のもの)。建玉がなければ0
+	Reason       string  // 目標に届かなかった理由。届いたなら空
+}
+
+// 目標の数量に届いたかどうか
+func (r *RebalanceResult) Reached() bool {
+	return math.Abs(r.Size-r.Target) < 1e-9
+}
+
+func (r *RebalanceResult) String() string {
+	s := fmt.Sprintf("target=%v, size=%v, opened=%d, closed=%d, losscut_value=%f", r.Target, r.Size, r.Opened, r.Closed, r.LosscutValue)
+	if r.Reason != "" {
+		s += ", reason=" + r.Reason
 	}
+	return s
 }
 
-// 建玉の数を指定した数に調整する
+// 買いポジションの数量の合計を指定した数量に調整する
+// 数量は取引単位に切り捨てる
 // できるだけ決済せずに済ます
-// すべて決済しても指定した数に届かないこともある
-func (a *Account) FixPositionSize(current float64, target int) {
+// 余力が足りなければ指定した数量に届かないこともあり、その理由を結果に含める
+// 調整後の全建玉のロスカット値は SetMinimumLosscutValue で揃える
+func (a *Account) FixPositionSize(current float64, target float64) *RebalanceResult {
+	spec := a.primary.instrument.Spec
+	target = spec.RoundLot(math.Max(target, 0))
+	r := &RebalanceResult{Target: target}
 	// 現在持っている全建玉のロスカットレートを同じにかつできる限り低くする
 	// なぜなら、単価が大きい建玉から決済すると余力が大きく取り戻せるようになるため
 	a.SetMinimumLosscutValue(current)
-	// ポジションの数が多いなら建単価が大きい玉から決済
-	for a.Positions().Size() > target {
+	// 数量が多いなら建単価が大きい玉から決済
+	for a.Positions().Quantity() > target+1e-9 {
 		a.CloseMax(current)
+		r.Closed++
 	}
-	if a.Positions().Size() == target {
-		return
+	// 足りなければ、注文の大きさか足りない数量の小さいほうずつ新規で建てる
+	// 任意証拠金を外して余力を最大にしてから、必要証拠金だけで建てられるか確認する
+	// 数量の合計の浮動小数点の誤差で、残りが取引単位に満たなくならないよう丸める
+	remainder := func() float64 {
+		return math.Round((target-a.Positions().Quantity())*1e9) / 1e9
+	}
+	for {
+		price := a.primary.buyPrice(current)
+		q := math.Min(a.primary.orderQuantity(price), spec.RoundLot(remainder()))
+		if q == 0 {
+			if remainder() > 0 {
+				r.Reason = fmt.Sprintf("order size is below the lot: remainder=%v", remainder())
+			}
+			break
+		}
+		a.releaseOptionalMargin()
+		lv := a.primary.newPosition(Long, price, q).NearestLosscutValue()
+		if !a.primary.canOpenQuantity(Long, current, lv, q) {
+			r.Reason = fmt.Sprintf("insufficient margin: remaining=%f", a.Remaining(current))
+			break
+		}
+		// net で売りポジションがあれば、代わりにそれが決済される
+		if a.OpenQuantity(current, lv, q) != 0 {
+			r.Opened++
+		}
+	}
+	a.SetMinimumLosscutValue(current)
+
+	r.Size = target - remainder()
+	if p := a.positions.MaxLosscut(); p != nil {
+		r.LosscutValue = p.LosscutValue()
+	}
+	return r
+}
+
+// 全建玉の任意証拠金を外して未拘束残高に戻す
+func (a *Account) releaseOptionalMargin() {
+	for i := a.positions.minItem; i != nil; i = i.next {
+		a.primary.bindMargin(-i.position.ClearOptionalMargin())
 	}
-	// 新規で建てる玉。既存建玉を決済することがあるので別で取っておく
-	// ps := []*Position{}
-	// TODO
-	// 余力が最も大きく増えるものから決済したいが、
-	// ロスカット値もレバレッジも一定ではないとき、建単価によって決定することができない
 }
 
 // 全建玉のロスカット値を、できるだけ同一かつできるだけ低くする
@@ -352,25 +857,64 @@ func (a *Account) SetMinimumLosscutValue(current float64) {
 	if a.positions.size == 0 {
 		return
 	}
-	// 全建玉の現在のロスカット値の平均を取得
+	// 全建玉の現在のロスカット値の、1価格単位あたりの約定代金 (数量と倍率) で重み付けした平均を取得
+	// ロスカット値を1下げるのに必要な証拠金はその重みに比例する
 	sum := a.Positions().sum(func(p *Position) float64 {
-		return p.LosscutValue()
+		return p.scale() * p.LosscutValue()
+	})
+	weight := a.Positions().sum(func(p *Position) float64 {
+		return p.scale()
 	})
-	target := (sum - a.Remaining(current)) / float64(a.positions.size)
+	target := (sum - a.primary.toQuote(a.Remaining(current))) / weight
 
 	i := a.positions.minItem
-	doneNum := 0
-	doneSum := 0.0
 	for i != nil {
-		m := i.position.AdditionalMarginToLosscutValue(target)
-		i.position.SetLosscutValue(target)
-		a.unboundCash -= m
-		if i.position.LosscutValue() != target {
-			target = (sum - doneSum - a.Remaining(current)) / float64(a.positions.size-doneNum)
+		p := i.position
+		sum -= p.scale() * p.LosscutValue()
+		weight -= p.scale()
+		m := p.AdditionalMarginToLosscutValue(target)
+		p.SetLosscutValue(target)
+		a.primary.bindMargin(m)
+		// 上限・下限で揃えられなければ、残りの余力を残りの建玉で分ける
+		if p.LosscutValue() != target && weight > 0 {
+			target = (sum - a.primary.toQuote(a.Remaining(current))) / weight
 		}
-
-		doneNum++
-		doneSum += i.position.LosscutValue()
 		i = i.next
 	}
 }
+
+// 口座から出金する
+// 出金できるのは余力の範囲まで
+// allowClose なら、余力が足りない分は建単価が大きいポジションから決済して捻出する
+// 実際に出金した額を返す
+func (a *Account) Withdraw(current float64, c float64, allowClose bool) float64 {
+	for allowClose && a.Remaining(current) < c && a.PositionCount() != 0 {
+		a.closeWorst(current)
+	}
+	w := math.Min(c, math.Max(a.Remaining(current), 0))
+	a.unboundCash -= w
+	return w
+}
+
+// 保有しているすべての建玉に、days 日分の金利調整額を適用する
+// primary の銘柄の時価は current で、ほかの銘柄は評価用の仲値で評価する
+// 受け取りは正、支払いは負で、未拘束残高に反映した合計を返す
+func (a *Account) ApplyFinancing(current float64, f *FinancingModel, date time.Time, days int) float64 {
+	c := 0.0
+	for _, h := range a.Holdings() {
+		notional := a.priceOf(h, a.primary, current) * h.instrument.Spec.Multiplier
+		c += h.toAccount(h.longs.Quantity() * f.Charge(notional, date, days))
+		c += h.toAccount(h.shorts.Quantity() * f.ShortCharge(notional, date, days))
+	}
+	a.unboundCash += c
+	return c
+}
+
+// primary の銘柄のすべての建玉に、指数1単位あたり amount の配当相当額を適用する
+// 買いポジションは受け取り、売りポジションは支払う
+// 未拘束残高に反映した合計を返す
+func (a *Account) ApplyDividend(amount float64) float64 {
+	c := a.primary.toAccount(amount * a.primary.instrument.Spec.Multiplier * (a.positions.Quantity() - a.shorts.Quantity()))
+	a.unboundCash += c
+	return c
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"testing"
 
 	"gopkg.in/go-playground/assert.v1"
@@ -47,7 +48,7 @@ func TestExecLosscut(t *testing.T) {
 
 	assert.Equal(t, 3, a.Positions().Size())
 	assert.Equal(t, 0.0, a.Remaining(2000))
-	a.ExecLosscut(1500)
+	a.ExecLosscut(2000, 1500)
 	assert.Equal(t, 1, a.Positions().Size())
 	assert.Equal(t, 200.0, a.Remaining(1500))
 }
@@ -136,3 +137,536 @@ func TestSetLosscutValueWithClose(t *testing.T) {
 		assert.Equal(t, 200.0, a.Remaining(2000))
 	}
 }
+
+func TestWithdraw(t *testing.T) {
+	{
+		// ポジションなし
+		a := NewAccount()
+
+		a.Deposit(1000)
+		assert.Equal(t, 300.0, a.Withdraw(1000, 300, false))
+		assert.Equal(t, 700.0, a.Remaining(1000))
+		assert.Equal(t, 700.0, a.Withdraw(1000, 1000, false))
+		assert.Equal(t, 0.0, a.Withdraw(1000, 1000, true))
+	}
+	{
+		// 余力の範囲でしか出金しない
+		a := NewAccount()
+
+		a.Deposit(1000)
+		a.Open(1000, 500)
+		r := a.Remaining(1000)
+		assert.Equal(t, r, a.Withdraw(1000, 1000, false))
+		assert.Equal(t, 1, a.Positions().Size())
+		assert.Equal(t, 0.0, a.Withdraw(1000, 1000, false))
+	}
+	{
+		// 決済して出金する
+		a := NewAccount()
+
+		a.Deposit(1000)
+		a.Open(1000, 500)
+		a.Open(2000, 1500)
+		v := a.Valuation(1000)
+		assert.Equal(t, v, a.Withdraw(1000, 2000, true))
+		assert.Equal(t, 0, a.Positions().Size())
+	}
+}
+
+func TestApplyFinancing(t *testing.T) {
+	a := NewAccount()
+	f := &FinancingModel{
+		Rate:     FixedRate(0.0365),
+		DayCount: 365,
+	}
+
+	a.Deposit(1000)
+	assert.Equal(t, 0.0, a.ApplyFinancing(1000, f, date("2020-01-10"), 3))
+	a.Open(1000, 900)
+	a.Open(1000, 900)
+	assert.Equal(t, 2, a.Positions().Size())
+	v := a.Valuation(1000)
+	c := a.ApplyFinancing(1000, f, date("2020-01-10"), 3)
+	assert.Equal(t, 2*f.Charge(1000, date("2020-01-10"), 3), c)
+	assert.Equal(t, v+c, a.Valuation(1000))
+}
+
+func TestApplyDividend(t *testing.T) {
+	a := NewAccount()
+
+	a.Deposit(1000)
+	assert.Equal(t, 0.0, a.ApplyDividend(1.5))
+	a.Open(1000, 900)
+	a.Open(1000, 900)
+	v := a.Valuation(1000)
+	assert.Equal(t, 3.0, a.ApplyDividend(1.5))
+	assert.Equal(t, v+3.0, a.Valuation(1000))
+}
+
+func TestTradeCosts(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{
+		SpreadPoints:    2,
+		SlippagePoints:  1,
+		CommissionFixed: 5,
+	})
+
+	a.Deposit(1000)
+	a.Open(1000, 900)
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, TradeCosts{Spread: 1, Slippage: 1, Commission: 5}, a.TradeCosts())
+	a.CloseMax(1000)
+	assert.Equal(t, TradeCosts{Spread: 2, Slippage: 2, Commission: 10}, a.TradeCosts())
+	// 往復でスプレッド、スリッページ、手数料の分だけ減る
+	assert.Equal(t, 1000.0-14, a.Valuation(1000))
+}
+
+func TestExecLosscutGap(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{GapSlippagePoints: 10})
+
+	a.Deposit(600)
+	a.Open(1000, 850)
+	a.Open(900, 700)
+	assert.Equal(t, 2, a.Positions().Size())
+
+	// 始値がロスカット値を割っていないのでロスカット値で約定
+	a.ExecLosscut(900, 800)
+	assert.Equal(t, 1, a.Positions().Size())
+	// 始値が窓を開けてロスカット値を割ったので、始値から滑って約定
+	a.ExecLosscut(600, 500)
+	assert.Equal(t, 0, a.Positions().Size())
+
+	es := a.LosscutEvents()
+	assert.Equal(t, 2, len(es))
+	assert.Equal(t, 850.0, es[0].Fill)
+	assert.Equal(t, false, es[0].Gap)
+	assert.Equal(t, 0.0, es[0].Slippage())
+	assert.Equal(t, 590.0, es[1].Fill)
+	assert.Equal(t, true, es[1].Gap)
+	assert.Equal(t, 110.0, es[1].Slippage())
+	// 850 - 1000 + 590 - 900
+	assert.Equal(t, 600.0-150-310, a.Valuation(500))
+}
+
+func TestMarginCallDeadline(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.Deposit(290)
+	a.Open(1000, 950)
+	a.Open(900, 855)
+	// 必要証拠金 190 に対して評価額 100 - 50 + 40 = 90
+	a.SetDate(date("2020-01-06"))
+	a.IssueMarginCall(850, date("2020-01-07"))
+	assert.NotEqual(t, nil, a.MarginCall())
+	assert.Equal(t, 100.0, a.MarginCall().Amount)
+	// 期限前で解消もされていない
+	a.SettleMarginCall(850)
+	assert.NotEqual(t, nil, a.MarginCall())
+	assert.Equal(t, 2, a.Positions().Size())
+
+	// 期限になっても解消されていないので強制決済
+	a.SetDate(date("2020-01-07"))
+	a.SettleMarginCall(850)
+	assert.Equal(t, (*MarginCall)(nil), a.MarginCall())
+	assert.Equal(t, 0, a.Positions().Size())
+
+	es := a.MarginCallEvents()
+	assert.Equal(t, 2, len(es))
+	assert.Equal(t, MarginCallPending, es[0].State)
+	assert.Equal(t, date("2020-01-07"), es[0].Deadline)
+	assert.Equal(t, 100.0, es[0].Shortfall())
+	assert.Equal(t, MarginCallForced, es[1].State)
+}
+
+func TestMarginCallResolved(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.Deposit(290)
+	a.Open(1000, 950)
+	a.Open(900, 855)
+	a.SetDate(date("2020-01-06"))
+	a.IssueMarginCall(880, date("2020-01-08"))
+	assert.Equal(t, 40.0, a.MarginShortfall(880))
+
+	// 一部決済で解消
+	a.SetDate(date("2020-01-07"))
+	assert.Equal(t, 1, a.ReduceForMarginCall(880))
+	a.SettleMarginCall(880)
+	assert.Equal(t, (*MarginCall)(nil), a.MarginCall())
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, MarginCallResolved, a.MarginCallEvents()[1].State)
+}
+
+func TestExecLosscutOrder(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.Deposit(1000)
+	a.Open(1000, 700)
+	a.Open(900, 850)
+	a.Open(1100, 800)
+	assert.Equal(t, 3, a.Positions().Size())
+
+	// 建単価が最大のポジションより、ロスカット値が大きいものが先にロスカットされる
+	a.ExecLosscut(900, 820)
+	assert.Equal(t, 2, a.Positions().Size())
+	assert.Equal(t, 1, len(a.LosscutEvents()))
+	assert.Equal(t, 900.0, a.LosscutEvents()[0].Unit)
+
+	a.ExecLosscut(820, 690)
+	assert.Equal(t, 0, a.Positions().Size())
+	es := a.LosscutEvents()
+	assert.Equal(t, 3, len(es))
+	assert.Equal(t, 800.0, es[1].LosscutValue)
+	assert.Equal(t, 700.0, es[2].LosscutValue)
+}
+
+func TestFixPositionSize(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.Deposit(520)
+
+	// 増やす
+	r := a.FixPositionSize(1000, 3)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, 3, r.Opened)
+	assert.Equal(t, 0, r.Closed)
+	assert.Equal(t, 3, a.Positions().Size())
+	// 余力をすべて任意証拠金にして、ロスカット値を揃える
+	for _, p := range a.Positions().ByLosscutValue() {
+		assert.Equal(t, r.LosscutValue, p.LosscutValue())
+	}
+	assert.Equal(t, 950.0-220.0/3, r.LosscutValue)
+
+	// 減らす
+	r = a.FixPositionSize(1000, 1)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, 2, r.Closed)
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, true, math.Abs(a.Valuation(1000)-520) < 1e-9)
+
+	// 届かない
+	r = a.FixPositionSize(1000, 10)
+	assert.Equal(t, false, r.Reached())
+	assert.Equal(t, 5.0, r.Size)
+	assert.Equal(t, 4, r.Opened)
+	assert.NotEqual(t, "", r.Reason)
+}
+
+func TestSetMinimumLosscutValue(t *testing.T) {
+	in := NewIndexInstrument("index", &BasicCostModel{})
+	in.Spec.LotMin = 0.1
+	in.Spec.LotStep = 0.1
+	a := NewAccountWithInstrument(in)
+	a.Deposit(300)
+	a.OpenQuantity(1000, 950, 0.5)
+	a.OpenQuantity(1000, 900, 0.5)
+	assert.Equal(t, true, math.Abs(a.Remaining(1000)-175) < 1e-9)
+
+	// 数量が1でなくても、余力をすべて使ってロスカット値を揃える
+	a.SetMinimumLosscutValue(1000)
+	for _, p := range a.Positions().ByLosscutValue() {
+		assert.Equal(t, true, math.Abs(p.LosscutValue()-750) < 1e-9)
+	}
+	assert.Equal(t, true, math.Abs(a.Remaining(1000)) < 1e-9)
+}
+
+func TestAccountSetLeverage(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.Deposit(500)
+
+	r := a.SetLeverage(1000, 4)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, 2, a.Positions().Size())
+
+	// 手数料の分だけ届かないが、終了はしない
+	a = NewAccountWithCostModel(&BasicCostModel{CommissionFixed: 1})
+	a.Deposit(500)
+	r = a.SetLeverage(1000, 10)
+	assert.Equal(t, 5.0, r.Target)
+	assert.Equal(t, 4.0, r.Size)
+	assert.Equal(t, false, r.Reached())
+
+	// 数量が1でなくても、数量でレバレッジを合わせる
+	in := NewIndexInstrument("index", &BasicCostModel{})
+	in.Spec.LotMin = 0.1
+	in.Spec.LotStep = 0.1
+	a = NewAccountWithInstrument(in)
+	a.SetOrderSize(OrderSize{Quantity: 0.1})
+	a.Deposit(1000)
+	r = a.SetLeverage(1000, 2)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, 20, r.Opened)
+	assert.Equal(t, true, math.Abs(a.Positions().Quantity()-2) < 1e-9)
+	assert.Equal(t, true, math.Abs(a.Leverage(1000)-2) < 1e-9)
+
+	// 注文の大きさで建て、端数は残りの数量で建てる
+	a = NewAccountWithInstrument(in)
+	a.SetOrderSize(OrderSize{Quantity: 0.3})
+	a.Deposit(1000)
+	r = a.FixPositionSize(1000, 1.05)
+	assert.Equal(t, 1.0, r.Target)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, 4, r.Opened)
+	assert.Equal(t, true, math.Abs(a.Positions().Quantity()-1) < 1e-9)
+
+	// 減らすときも数量で比べる
+	r = a.FixPositionSize(1000, 0.6)
+	assert.Equal(t, true, r.Reached())
+	assert.Equal(t, true, math.Abs(a.Positions().Quantity()-0.6) < 1e-9)
+}
+
+func TestOpenShort(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{SpreadPoints: 2})
+	a.Deposit(400)
+
+	a.OpenShort(1000, 1100)
+	assert.Equal(t, 1, a.Shorts().Size())
+	assert.Equal(t, 0, a.Positions().Size())
+	p := a.Shorts().Min()
+	// 売値で建てる
+	assert.Equal(t, 999.0, p.Unit())
+	assert.Equal(t, 1100.0, p.LosscutValue())
+	// 買値で評価する
+	assert.Equal(t, 400.0-2, a.Valuation(1000))
+	assert.Equal(t, 400.0+48, a.Valuation(950))
+
+	// 両建て
+	a.Open(1000, 900)
+	assert.Equal(t, 1, a.Shorts().Size())
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, 0.1*999+0.1*1001, a.RequiredMargin())
+	// 評価損益は打ち消し合う
+	assert.Equal(t, 400.0-4, a.Valuation(1100))
+
+	a.CloseAll(1000)
+	assert.Equal(t, 0, a.PositionCount())
+	assert.Equal(t, 400.0-4, a.Valuation(1000))
+}
+
+func TestOpenNet(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.SetBookMode(BookNet)
+	a.Deposit(300)
+
+	a.Open(1000, 900)
+	a.Open(1000, 900)
+	// 買いがあるので決済する
+	a.OpenShort(1000, 1100)
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, 0, a.Shorts().Size())
+	a.OpenShort(1000, 1100)
+	a.OpenShort(1000, 1100)
+	assert.Equal(t, 0, a.Positions().Size())
+	assert.Equal(t, 1, a.Shorts().Size())
+}
+
+func TestExecLosscutShort(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{GapSlippagePoints: 10})
+	a.Deposit(1000)
+	a.OpenShort(1000, 1100)
+	a.OpenShort(1000, 1200)
+	a.Open(1000, 900)
+
+	// 上昇で売りだけロスカットされる
+	a.ExecLosscut(1000, 1150)
+	assert.Equal(t, 1, a.Shorts().Size())
+	assert.Equal(t, 1, a.Positions().Size())
+	// 窓を開けて上昇
+	a.ExecLosscut(1300, 1300)
+	assert.Equal(t, 0, a.Shorts().Size())
+
+	es := a.LosscutEvents()
+	assert.Equal(t, 2, len(es))
+	assert.Equal(t, Short, es[0].Side)
+	assert.Equal(t, 1100.0, es[0].Fill)
+	assert.Equal(t, false, es[0].Gap)
+	assert.Equal(t, 1310.0, es[1].Fill)
+	assert.Equal(t, true, es[1].Gap)
+	assert.Equal(t, 110.0, es[1].Slippage())
+}
+
+// 必要証拠金は建単価の5%、ロスカット幅は2%
+func goldInstrument() *Instrument {
+	spec, _ := NewContractSpecPreset("commodity-cfd")
+	spec.LosscutRate = 0.02
+	return &Instrument{Name: "gold", Cost: &BasicCostModel{}, Spec: spec}
+}
+
+func TestAddInstrument(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	gold, err := a.AddInstrument(goldInstrument())
+	assert.Equal(t, nil, err)
+	_, err = a.AddInstrument(NewIndexInstrument("gold", &BasicCostModel{}))
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, gold, a.Holding("gold"))
+	assert.Equal(t, a.Primary(), a.Holding("index"))
+	assert.Equal(t, true, a.Holding("crude") == nil)
+	assert.Equal(t, 2, len(a.Holdings()))
+
+	// 現金と証拠金を共有する
+	a.Deposit(200)
+	gold.SetMark(1000)
+	gold.Open(1000, 980)
+	assert.Equal(t, 50.0, gold.RequiredMargin())
+	a.Open(1000, 950)
+	assert.Equal(t, 150.0, a.RequiredMargin())
+	assert.Equal(t, 2, a.PositionCount())
+	assert.Equal(t, false, a.CanOpen(1000, 950))
+
+	// ほかの銘柄の評価損も余力と評価額に反映される
+	gold.SetMark(990)
+	assert.Equal(t, 40.0, a.Remaining(1000))
+	assert.Equal(t, 190.0, a.Valuation(1000))
+
+	gold.ExecLosscut(1000, 970)
+	assert.Equal(t, 0, gold.PositionCount())
+	es := a.LosscutEvents()
+	assert.Equal(t, 1, len(es))
+	assert.Equal(t, "gold", es[0].Instrument)
+}
+
+func TestExecMarginCallLiquidation(t *testing.T) {
+	// 指数の買い2つ (必要証拠金 100 ずつ) と金の買い1つ (50)
+	// 金の評価損が 40、指数の評価損が 5 ずつで、不足は 40
+	setup := func(p LiquidationPolicy) (*Account, *Holding) {
+		a := NewAccountWithCostModel(&BasicCostModel{})
+		a.SetLiquidationPolicy(p)
+		gold, _ := a.AddInstrument(goldInstrument())
+		a.Deposit(260)
+		a.Open(1000, 950)
+		a.Open(1000, 950)
+		gold.SetMark(1000)
+		gold.Open(1000, 980)
+		gold.SetMark(960)
+		assert.Equal(t, 40.0, a.MarginShortfall(995))
+		a.ExecMarginCall(995)
+		return a, gold
+	}
+
+	a, gold := setup(LiquidateAll)
+	assert.Equal(t, 0, a.PositionCount())
+	assert.Equal(t, 210.0, a.Valuation(995))
+
+	// 評価損が大きい金から決済して不足が解消する
+	a, gold = setup(LiquidateWorstFirst)
+	assert.Equal(t, 0, gold.PositionCount())
+	assert.Equal(t, 2, a.Positions().Size())
+
+	// 必要証拠金が大きい指数から決済して不足が解消する
+	a, gold = setup(LiquidateLargestMargin)
+	assert.Equal(t, 1, gold.PositionCount())
+	assert.Equal(t, 1, a.Positions().Size())
+	assert.Equal(t, 0.0, a.MarginShortfall(995))
+	assert.Equal(t, 1, len(a.MarginCallEvents()))
+}
+
+func TestExecMarginCallLiquidationMixedQuantity(t *testing.T) {
+	// 1000 で1枚 (必要証拠金 100) と 950 で3枚 (285)
+	// 900 では評価損が 100 と 150 で、不足は 35
+	// 建単価が大きい1枚ではなく、評価損も必要証拠金も大きい3枚を決済する
+	for _, p := range []LiquidationPolicy{LiquidateWorstFirst, LiquidateLargestMargin} {
+		a := NewAccountWithCostModel(&BasicCostModel{})
+		a.SetLiquidationPolicy(p)
+		a.Deposit(600)
+		a.Open(1000, 950)
+		assert.Equal(t, 3.0, a.OpenQuantity(950, 850, 3))
+		assert.Equal(t, 35.0, a.MarginShortfall(900))
+		a.ExecMarginCall(900)
+		assert.Equal(t, 1, a.Positions().Size())
+		assert.Equal(t, 1000.0, a.Positions().Max().Unit())
+		assert.Equal(t, 1.0, a.Positions().Max().Quantity())
+		assert.Equal(t, 0.0, a.MarginShortfall(900))
+	}
+}
+
+func TestOpenQuantity(t *testing.T) {
+	in := NewIndexInstrument("index", &BasicCostModel{})
+	in.Spec.LotMin = 0.1
+	in.Spec.LotStep = 0.1
+	a := NewAccountWithInstrument(in)
+	a.Deposit(280)
+
+	// 取引単位に切り捨てる
+	assert.Equal(t, 1.0, a.OpenQuantity(1000, 950, 1.05))
+	assert.Equal(t, 100.0, a.RequiredMargin())
+	assert.Equal(t, 0.5, a.OpenAmount(1000, 950, 500))
+	assert.Equal(t, 0.0, a.OpenQuantity(1000, 950, 0.05))
+	assert.Equal(t, 1.3, a.MaxOpenQuantity(1000, 950))
+
+	// 数量1で建てた残りを取引単位で建てる
+	assert.Equal(t, 2, a.FullOpen(1000, 950))
+	assert.Equal(t, 4, a.Positions().Size())
+	assert.Equal(t, true, math.Abs(a.Positions().Quantity()-2.8) < 1e-9)
+	assert.Equal(t, true, math.Abs(a.Valuation(1100)-560) < 1e-9)
+	assert.Equal(t, true, math.Abs(a.Leverage(1000)-10) < 1e-9)
+
+	// 約定代金で注文の大きさを決める
+	a = NewAccountWithInstrument(in)
+	a.SetOrderSize(OrderSize{Amount: 250})
+	a.Deposit(100)
+	assert.Equal(t, 5, a.FullOpen(1000, 950))
+	assert.Equal(t, 0.2, a.Positions().Min().Quantity())
+}
+
+func TestOpenWithTick(t *testing.T) {
+	spec, _ := NewContractSpecPreset("stock-margin-jp")
+	spec.LotMin, spec.LotStep = 1, 1
+	a := NewAccountWithInstrument(&Instrument{Name: "stock", Cost: &BasicCostModel{}, Spec: spec})
+	a.Deposit(1000.5)
+
+	// 約定値は呼値の単位に切り上げた1001なので、切り上げる前の1000.3では足りても建てられない
+	assert.Equal(t, false, a.CanOpen(1000.3, 0))
+	assert.Equal(t, 0.0, a.MaxOpenQuantity(1000.3, 0))
+	assert.Equal(t, 0, a.FullOpen(1000.3, 0))
+	assert.Equal(t, 1000.5, a.Remaining(1000.3))
+
+	// 約定値が1000なら建てられる
+	assert.Equal(t, 1, a.FullOpen(999.6, 0))
+	assert.Equal(t, 1000.0, a.Positions().Min().Unit())
+}
+
+func TestForeignCurrency(t *testing.T) {
+	in := NewIndexInstrument("index", &BasicCostModel{})
+	in.Currency = "USD"
+	a := NewAccountWithInstrument(in)
+	a.SetCurrency("JPY")
+	a.Primary().SetFXRate(100)
+	a.Deposit(20000)
+
+	// 証拠金は円で拘束する
+	a.Open(1000, 950)
+	assert.Equal(t, 10000.0, a.RequiredMargin())
+	assert.Equal(t, false, a.CanOpen(1000, 900))
+	assert.Equal(t, 20000.0, a.Valuation(1000))
+
+	// 円高になると評価益は減るが、拘束した証拠金は変わらない
+	a.Primary().SetFXRate(80)
+	assert.Equal(t, 28000.0, a.Valuation(1100))
+	assert.Equal(t, 8000.0, a.RequiredMargin())
+	assert.Equal(t, true, math.Abs(a.Leverage(1100)-1100.0*80/28000) < 1e-9)
+
+	a.CloseMax(1100)
+	assert.Equal(t, 28000.0, a.Valuation(1100))
+	assert.Equal(t, 28000.0, a.Remaining(1100))
+
+	// 通貨が同じなら換算しない
+	a = NewAccountWithCostModel(&BasicCostModel{})
+	a.SetCurrency("JPY")
+	a.Primary().SetFXRate(100)
+	a.Deposit(200)
+	a.Open(1000, 950)
+	assert.Equal(t, 100.0, a.RequiredMargin())
+}
+
+func TestDrainFills(t *testing.T) {
+	a := NewAccountWithCostModel(&BasicCostModel{})
+	a.SetDate(date("2020-01-02"))
+	a.Deposit(300)
+	a.Open(1000, 950)
+	a.CloseMax(1010)
+
+	fs := a.DrainFills()
+	assert.Equal(t, 2, len(fs))
+	assert.Equal(t, Long, fs[0].Side)
+	assert.Equal(t, 1000.0, fs[0].Price)
+	assert.Equal(t, Short, fs[1].Side)
+	assert.Equal(t, 1010.0, fs[1].Price)
+	assert.Equal(t, 1.0, fs[1].Quantity)
+	assert.Equal(t, primaryInstrument, fs[1].Instrument)
+	assert.Equal(t, 0, len(a.DrainFills()))
+}
@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// 株価指数と IV のほかに、設定が参照するCSVのデータ
+// 読み込んだ後は読み取り専用なので、同じ設定の複数のバックテスト
+// (スイープの組み合わせ、ウォークフォワードの期間、retire の開始日) で共有する
+type auxData struct {
+	rate        *RateSeries             // financing.rate_csv の金利。なければ nil
+	dividends   *DividendSchedule       // dividend.csv の配当。なければ nil
+	totalReturn []*DailyData            // dividend.total_return の株価指数。なければ nil
+	instruments map[string][]*DailyData // instruments の name ごとの価格。株価指数の日付には揃えていない
+	fx          map[string]*FXRates     // 口座と違う通貨ごとの為替レート
+}
+
+// 設定が参照するCSVを読み込む
+// 設定は normalize 済みであること
+func loadAuxData(c *Config) (*auxData, error) {
+	d := &auxData{
+		instruments: map[string][]*DailyData{},
+		fx:          map[string]*FXRates{},
+	}
+	var err error
+	if d.rate, err = c.Financing.rateSeries(c.Data.DateLayouts); err != nil {
+		return nil, err
+	}
+	if d.dividends, d.totalReturn, err = c.Dividend.read(c.Data.csvOptions()); err != nil {
+		return nil, err
+	}
+	currencies := []string{c.Currency.Index}
+	for _, ic := range c.Instruments {
+		ds, err := ReadDailyDataWithOptions(ic.CSV, c.Data.csvOptions())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s csv: %v", ic.Name, err)
+		}
+		d.instruments[ic.Name] = ds
+		currencies = append(currencies, ic.Currency)
+	}
+	for _, cur := range currencies {
+		if cur == "" || cur == c.Currency.Account || d.fx[cur] != nil {
+			continue
+		}
+		if d.fx[cur], err = c.Currency.rates(cur, c.Data.DateLayouts); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
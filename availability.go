@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+// 株価指数の寄り付きから大引けまでの分数 (米国市場の 9:30 ~ 16:00)
+const sessionMinutes = 390
+
+// 系列のデータがいつ分かるか
+// 時刻は株価指数の寄り付きからの分で表す。戦略は寄り付き (0) で判断する
+type Availability struct {
+	Open  float64 // 始値が分かる時刻
+	Close float64 // 高値・安値・終値・出来高が分かる時刻
+	Lag   int     // 戦略に見せるのを遅らせる日数
+}
+
+// 設定がないときの系列 name のデータが分かる時刻
+// 寄り付きで始値が、大引けでそれ以外が分かる
+// ただし iv (VIX) の始値は株価指数の寄り付きの後に計算されるため、寄り付きでは前日の終値までしか分からない
+func DefaultAvailability(name string) Availability {
+	if name == SeriesIV {
+		return Availability{Open: 1, Close: sessionMinutes}
+	}
+	return Availability{Open: 0, Close: sessionMinutes}
+}
+
+// 時刻 now に分かっている部分だけの足
+// 何も分かっていなければ nil、始値だけなら始値以外を NaN にしたもの
+func (a Availability) view(d *DailyData, now float64) *DailyData {
+	switch {
+	case d == nil || now < a.Open:
+		return nil
+	case now >= a.Close:
+		return d
+	}
+	nan := math.NaN()
+	return &DailyData{date: d.date, open: d.open, high: nan, low: nan, close: nan, adjClose: nan, volume: nan}
+}
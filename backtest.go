@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// 設定に従ってバックテストを実行
+func runConfig(c *Config) (*result, error) {
+	if err := c.normalize(); err != nil {
+		return nil, err
+	}
+	index, iv, jr, err := readData(c.Data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newBacktest(c, index, iv)
+	if err != nil {
+		return nil, err
+	}
+	r := b.run()
+	r.join = jr
+	return r, nil
+}
+
+// ひとつのバックテスト
+// 戦略や口座は状態を持つので、実行ごとに作り直すこと
+type backtest struct {
+	strategy   Strategy
+	account    *Account
+	deposit    *DepositPlan
+	withdrawal *WithdrawalPlan   // nil なら出金しない
+	financing  *FinancingModel   // nil なら金利調整額はかからない
+	dividend   *DividendSchedule // nil なら配当相当額はない
+	path       PathModel
+	marginCall MarginCallConfig
+	index      []*DailyData
+	iv         []*DailyData
+	others     []*instrumentSeries // 株価指数のほかに持つ銘柄
+	avail      map[string]Availability
+	fx         []*fxSeries // 通貨が口座と違う銘柄の為替レート
+
+	// 戦略に通知済みのロスカットと追証の数
+	notifiedLosscuts    int
+	notifiedMarginCalls int
+}
+
+// 株価指数と IV の日付が揃っているか確かめる
+func checkAligned(index, iv []*DailyData) error {
+	if len(index) != len(iv) {
+		return fmt.Errorf("length mismatch: index=%d, iv=%d", len(index), len(iv))
+	}
+	for i, d := range index {
+		if !d.date.Equal(iv[i].date) {
+			return fmt.Errorf("date mismatch: index=%s, iv=%s", formatDate(d.date), formatDate(iv[i].date))
+		}
+	}
+	return nil
+}
+
+// 通貨が口座と違う銘柄と、その為替レート
+type fxSeries struct {
+	holding *Holding
+	rates   *FXRates
+}
+
+// 株価指数のほかに持つ銘柄と、株価指数の日付に揃えた価格
+type instrumentSeries struct {
+	holding *Holding
+	data    []*DailyData // 価格がまだない日は nil
+}
+
+// 設定と日付を揃えたデータからバックテストを作る
+// 設定は normalize 済みであること
+func newBacktest(c *Config, index, iv []*DailyData) (*backtest, error) {
+	aux, err := loadAuxData(c)
+	if err != nil {
+		return nil, err
+	}
+	return newBacktestWithData(c, index, iv, aux)
+}
+
+// 読み込み済みの補助のデータを使ってバックテストを作る
+// 同じ設定で何度もバックテストを作るなら、loadAuxData は一度だけ呼んで共有する
+func newBacktestWithData(c *Config, index, iv []*DailyData, aux *auxData) (*backtest, error) {
+	if err := checkAligned(index, iv); err != nil {
+		return nil, err
+	}
+	s, err := newStrategy(c.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	from, _, err := c.Data.period()
+	if err != nil {
+		return nil, err
+	}
+	deposit, err := c.Deposit.plan(from)
+	if err != nil {
+		return nil, err
+	}
+	withdrawal, err := c.Withdrawal.plan()
+	if err != nil {
+		return nil, err
+	}
+	financing, err := c.Financing.model(aux.rate)
+	if err != nil {
+		return nil, err
+	}
+	dividend, err := c.Dividend.schedule(index, aux.dividends, aux.totalReturn)
+	if err != nil {
+		return nil, err
+	}
+	cost, err := c.Broker.costModel()
+	if err != nil {
+		return nil, err
+	}
+	path, err := c.Intraday.model()
+	if err != nil {
+		return nil, err
+	}
+	spec, err := c.Contract.spec()
+	if err != nil {
+		return nil, err
+	}
+	account := NewAccountWithInstrument(&Instrument{Name: primaryInstrument, Currency: c.Currency.Index, Cost: cost, Spec: spec})
+	account.SetCurrency(c.Currency.Account)
+	account.SetOrderSize(c.Order.size())
+	account.SetLiquidationPolicy(LiquidationPolicy(c.MarginCall.Liquidation))
+	others := []*instrumentSeries{}
+	for _, ic := range c.Instruments {
+		in, err := ic.instrument()
+		if err != nil {
+			return nil, err
+		}
+		h, err := account.AddInstrument(in)
+		if err != nil {
+			return nil, err
+		}
+		ds, err := AlignDailyData(index, aux.instruments[ic.Name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ic.Name, err)
+		}
+		others = append(others, &instrumentSeries{holding: h, data: ds})
+	}
+	account.SetBookMode(BookMode(c.Broker.Book))
+	fx := []*fxSeries{}
+	for _, h := range account.Holdings() {
+		if !h.foreign() {
+			continue
+		}
+		cur := h.Instrument().Currency
+		rates := aux.fx[cur]
+		// 最初の日付より前は最初の値で代用することになるので、データの初めを覆っていなければエラー
+		if len(index) != 0 && rates.Start().After(index[0].date) {
+			return nil, fmt.Errorf("currency.rates.%s: rates start at %s, after the first index date %s", cur, formatDate(rates.Start()), formatDate(index[0].date))
+		}
+		fx = append(fx, &fxSeries{holding: h, rates: rates})
+	}
+	return &backtest{
+		strategy:   s,
+		account:    account,
+		deposit:    deposit,
+		withdrawal: withdrawal,
+		financing:  financing,
+		dividend:   dividend,
+		path:       path,
+		marginCall: c.MarginCall,
+		index:      index,
+		iv:         iv,
+		others:     others,
+		fx:         fx,
+		avail:      availabilities(c.Availability),
+	}, nil
+}
+
+// バックテストの結果
+type result struct {
+	initialDeposit  float64
+	totalDeposit    float64
+	totalWithdrawal float64
+	shortfall       float64   // 出金しようとしてできなかった額の合計
+	ruinDate        time.Time // 初めて予定どおり出金できなかった日。ゼロ値なら破綻していない
+	valuations      []*dailyValuation
+	ledger          *ledger
+	losscuts        []*LosscutEvent
+	marginCalls     []*MarginCallEvent
+	topUp           float64 // 追証のための入金の合計 (totalDeposit に含む)
+	join            *JoinReport
+}
+
+// 破綻したかどうか
+func (r *result) ruined() bool {
+	return !r.ruinDate.IsZero()
+}
+
+// from 以降だけを評価した結果
+// from の前日の評価額を初回の入金とみなし、前の日々は戦略の準備 (ウォームアップ) として捨てる
+// from 以降の日がなければ nil
+func (r *result) since(from time.Time) *result {
+	vs := r.valuations
+	k := 0
+	for k < len(vs) && vs[k].date.Before(from) {
+		k++
+	}
+	if k == len(vs) {
+		return nil
+	}
+	if k == 0 {
+		return r
+	}
+	base, last := vs[k-1], vs[len(vs)-1]
+	s := *r
+	// 入出金の累計も前日の評価額を初回の入金として数え直す
+	s.valuations = []*dailyValuation{}
+	for _, v := range vs[k:] {
+		s.valuations = append(s.valuations, &dailyValuation{
+			date:       v.date,
+			valuation:  v.valuation,
+			deposit:    base.valuation + v.deposit - base.deposit,
+			withdrawal: v.withdrawal - base.withdrawal,
+		})
+	}
+	s.initialDeposit = base.valuation
+	s.totalDeposit = base.valuation + last.deposit - base.deposit
+	s.totalWithdrawal = last.withdrawal - base.withdrawal
+	s.losscuts = []*LosscutEvent{}
+	for _, e := range r.losscuts {
+		if !e.Date.Before(from) {
+			s.losscuts = append(s.losscuts, e)
+		}
+	}
+	s.marginCalls = []*MarginCallEvent{}
+	for _, e := range r.marginCalls {
+		if !e.Date.Before(from) {
+			s.marginCalls = append(s.marginCalls, e)
+		}
+	}
+	return &s
+}
+
+// 開始から破綻までの年数
+func (r *result) yearsUntilRuin() float64 {
+	if !r.ruined() {
+		return math.Inf(1)
+	}
+	return r.ruinDate.Sub(r.valuations[0].date).Hours() / 24 / 365.25
+}
+
+// バックテストを実行
+func (b *backtest) run() *result {
+	a := b.account
+	s := b.strategy
+	plan := b.deposit
+	index := b.index
+	iv := b.iv
+
+	r := &result{
+		initialDeposit: plan.Initial,
+		ledger:         newLedger(),
+	}
+
+	if plan.Start.IsZero() {
+		plan.Start = index[0].date
+	}
+	if b.withdrawal != nil && b.withdrawal.Start.IsZero() {
+		b.withdrawal.Start = index[0].date
+	}
+	a.Deposit(plan.Initial)
+	r.totalDeposit += plan.Initial
+	s.OnStart(a)
+	series := b.series()
+	// 前回入金を確認した日。この日より後の入金予定を処理する
+	depositedUntil := plan.Start.AddDate(0, 0, -1)
+	// 評価額の最高値
+	high := 0.0
+	// 前営業日までの取引コストの累計
+	prevCosts := a.TradeCosts()
+
+	vs := []*dailyValuation{}
+
+	for i := range index {
+		d := index[i]
+		v := iv[i]
+
+		a.SetDate(d.date)
+		for _, f := range b.fx {
+			f.holding.SetFXRate(f.rates.RateAtOpen(d.date))
+		}
+		// ほかの銘柄は始値で評価する
+		a.Primary().SetMark(d.open)
+		for _, o := range b.others {
+			if od := o.data[i]; od != nil {
+				o.holding.SetMark(od.open)
+			}
+		}
+
+		if due := plan.Due(depositedUntil, d.date); due != 0 {
+			a.Deposit(due)
+			r.totalDeposit += due
+		}
+		depositedUntil = d.date
+
+		// 前営業日から持ち越した建玉への配当相当額
+		if b.dividend != nil && i != 0 {
+			if amount := b.dividend.Due(index[i-1].date, d.date); amount != 0 {
+				c := a.ApplyDividend(amount)
+				r.ledger.add("dividend", d.date, c)
+			}
+		}
+
+		if w := b.withdrawal; w != nil && i != 0 && w.IsDue(index[i-1].date, d.date) {
+			req := w.Request(a.Valuation(d.open), high)
+			got := a.Withdraw(d.open, req, w.AllowClose)
+			r.totalWithdrawal += got
+			if got < req {
+				r.shortfall += req - got
+				if !r.ruined() {
+					r.ruinDate = d.date
+					log.Printf("Ruined: requested=%f, withdrawn=%f", req, got)
+				}
+			}
+		}
+
+		a.CostModel().SetVolatility(v.open)
+
+		// 期限前の追証があれば、入金や一部決済で解消を試みる
+		if a.MarginCall() != nil {
+			if due := plan.TopUpAmount(a.MarginShortfall(d.open)); due != 0 {
+				a.Deposit(due)
+				r.totalDeposit += due
+				r.topUp += due
+			}
+			if b.marginCall.Reduce {
+				a.ReduceForMarginCall(d.open)
+			}
+		}
+
+		// 始値でロスカット値や証拠金を割っていれば、戦略が動く前に強制決済する
+		immediate := MarginCallMode(b.marginCall.Mode) == MarginCallImmediate
+		for _, o := range b.others {
+			if od := o.data[i]; od != nil {
+				o.holding.ExecLosscut(od.open, od.open)
+			}
+		}
+		a.ExecLosscut(d.open, d.open)
+		if immediate {
+			a.ExecMarginCall(d.open)
+		}
+
+		b.notify()
+		s.OnBar(&BarContext{Account: a, Date: d.date, Price: d.open, i: i, series: series, avail: b.avail})
+		a.SettleMarginCall(d.open)
+		b.notify()
+
+		// 日中の値動きに沿ってロスカットと追証を判定する
+		// ほかの銘柄も同じ時刻の価格で評価する
+		paths := make([][]float64, len(b.others))
+		for j, o := range b.others {
+			if od := o.data[i]; od != nil {
+				paths[j] = b.path.Path(od)
+			}
+		}
+		for k, p := range b.path.Path(d) {
+			for j, o := range b.others {
+				if op := paths[j]; op != nil {
+					// 分割数が違えば終値で止める
+					price := op[len(op)-1]
+					if k < len(op) {
+						price = op[k]
+					}
+					o.holding.SetMark(price)
+					o.holding.ExecLosscut(o.data[i].open, price)
+				}
+			}
+			a.Primary().SetMark(p)
+			a.ExecLosscut(d.open, p)
+			if immediate {
+				a.ExecMarginCall(p)
+			}
+		}
+		// 大引けでは今日の為替レートで評価する
+		for _, f := range b.fx {
+			f.holding.SetFXRate(f.rates.Rate(d.date))
+		}
+		if !immediate {
+			a.IssueMarginCall(d.close, marginCallDeadline(index, i, b.marginCall.GraceDays))
+		}
+		b.notify()
+
+		// 翌営業日までの金利調整額
+		if b.financing != nil {
+			next := time.Time{}
+			if i+1 < len(index) {
+				next = index[i+1].date
+			}
+			c := a.ApplyFinancing(d.close, b.financing, d.date, financingDays(d.date, next))
+			r.ledger.add("financing", d.date, c)
+		}
+
+		tc := a.TradeCosts()
+		for kind, c := range map[string]float64{
+			"spread":     tc.Spread - prevCosts.Spread,
+			"slippage":   tc.Slippage - prevCosts.Slippage,
+			"commission": tc.Commission - prevCosts.Commission,
+		} {
+			if c != 0 {
+				r.ledger.add(kind, d.date, -c)
+			}
+		}
+		prevCosts = tc
+
+		valuation := a.Valuation(d.close)
+		high = math.Max(high, valuation)
+		vs = append(vs, &dailyValuation{date: d.date, valuation: valuation, deposit: r.totalDeposit, withdrawal: r.totalWithdrawal})
+
+		log.Printf("%s done", formatDate(d.date))
+	}
+
+	s.OnEnd(a)
+
+	r.valuations = vs
+	r.losscuts = a.LosscutEvents()
+	r.marginCalls = a.MarginCallEvents()
+	return r
+}
+
+// 戦略に見せる価格の系列
+func (b *backtest) series() map[string][]*DailyData {
+	m := map[string][]*DailyData{
+		SeriesIndex: b.index,
+		SeriesIV:    b.iv,
+	}
+	for _, o := range b.others {
+		m[o.holding.Instrument().Name] = o.data
+	}
+	return m
+}
+
+// 前回から起きた約定、ロスカット、追証を戦略に通知する
+func (b *backtest) notify() {
+	a := b.account
+	for _, e := range a.DrainFills() {
+		b.strategy.OnFill(e)
+	}
+	ls := a.LosscutEvents()
+	for _, e := range ls[b.notifiedLosscuts:] {
+		b.strategy.OnLosscut(e)
+	}
+	b.notifiedLosscuts = len(ls)
+	ms := a.MarginCallEvents()
+	for _, e := range ms[b.notifiedMarginCalls:] {
+		b.strategy.OnMarginCall(e)
+	}
+	b.notifiedMarginCalls = len(ms)
+}
+
+// i 番目の営業日に発生した追証の期限
+// データの終わりを越える場合は、最終日の翌日 (つまり期限は来ない)
+func marginCallDeadline(index []*DailyData, i, graceDays int) time.Time {
+	if i+graceDays < len(index) {
+		return index[i+graceDays].date
+	}
+	return index[len(index)-1].date.AddDate(0, 0, 1)
+}
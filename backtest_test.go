@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBacktestDateMismatch(t *testing.T) {
+	index, iv := syntheticData(10)
+	c := syntheticConfig(t)
+	_, err := newBacktest(c, index, iv)
+	assert.NoError(t, err)
+
+	// 日付が揃っていなければ、プロセスを止めずにエラーを返す
+	shifted := append([]*DailyData{}, iv...)
+	shifted[5] = &DailyData{date: iv[5].date.Add(24 * time.Hour)}
+	_, err = newBacktest(c, index, shifted)
+	assert.Error(t, err)
+	_, err = newBacktest(c, index, iv[:9])
+	assert.Error(t, err)
+}
+
+// 毎日始値でレバレッジを掛け直す戦略
+type releverageStrategy struct {
+	BaseStrategy
+	leverage float64
+	losscuts int
+}
+
+func (s *releverageStrategy) OnBar(c *BarContext) {
+	c.Account.SetLeverageWithClose2(c.Price, s.leverage)
+	c.Account.FullOpenWithLeverage2(c.Price, s.leverage)
+}
+
+func (s *releverageStrategy) OnLosscut(e *LosscutEvent) {
+	s.losscuts++
+}
+
+func TestRunGapLosscutBeforeStrategy(t *testing.T) {
+	index, iv := syntheticData(10)
+	for i, d := range index {
+		// 6日目の始値でロスカット値を下に飛び越える
+		p := 1000.0
+		if i >= 5 {
+			p = 850
+		}
+		d.open, d.high, d.low, d.close = p, p, p, p
+	}
+	c := syntheticConfig(t)
+	b, err := newBacktest(c, index, iv)
+	assert.NoError(t, err)
+	s := &releverageStrategy{leverage: 10}
+	b.strategy = s
+	r := b.run()
+
+	// 戦略が掛け直したり決済したりする前に、始値でロスカットされる
+	assert.NotEmpty(t, r.losscuts)
+	for _, e := range r.losscuts {
+		assert.Equal(t, index[5].date, e.Date)
+		assert.True(t, e.Gap)
+	}
+	assert.Equal(t, len(r.losscuts), s.losscuts)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 系列の名前
+// 株価指数のほかに持つ銘柄は、その銘柄の名前
+const (
+	SeriesIndex = primaryInstrument // 株価指数
+	SeriesIV    = "iv"              // ボラティリティインデックス
+)
+
+// 戦略が日足ごとに受け取る情報
+// 価格の系列は読み取りだけできる
+// 戦略は株価指数の寄り付きで判断するので、その時点で分かっているデータだけを見せる
+type BarContext struct {
+	Account *Account
+	Date    time.Time
+	Price   float64                 // 注文が約定する価格 (株価指数の始値)
+	i       int                     // 系列の中での今日の位置
+	series  map[string][]*DailyData // 日付は揃えてある。データがない日は nil
+	avail   map[string]Availability // ない系列は DefaultAvailability
+}
+
+func (c *BarContext) availability(name string) Availability {
+	if a, ok := c.avail[name]; ok {
+		return a
+	}
+	return DefaultAvailability(name)
+}
+
+// 系列 name の今日の足
+// 遅らせる日数があれば、その日数だけ前の足になる
+// 分かっていない値は NaN で、何も分かっていないか、系列やデータがなければ nil
+func (c *BarContext) Bar(name string) *DailyData {
+	ds, ok := c.series[name]
+	if !ok {
+		return nil
+	}
+	a := c.availability(name)
+	i := c.i - a.Lag
+	switch {
+	case i < 0:
+		return nil
+	case a.Lag > 0:
+		// 前日以前の足はすべて分かっている
+		return ds[i]
+	}
+	return a.view(ds[i], 0)
+}
+
+// 系列 name の Bar より前の足
+func (c *BarContext) History(name string) History {
+	ds, ok := c.series[name]
+	if !ok {
+		return History{}
+	}
+	i := c.i - c.availability(name).Lag
+	if i < 0 {
+		i = 0
+	}
+	return History{data: ds[:i]}
+}
+
+// 系列 name の判断の時点で分かっている最新の価格
+// 今日の終値、今日の始値、前日までの終値の順に探す。なければ NaN
+func (c *BarContext) Last(name string) float64 {
+	if d := c.Bar(name); d != nil {
+		if !math.IsNaN(d.close) {
+			return d.close
+		}
+		return d.open
+	}
+	h := c.History(name)
+	for n := 1; n <= h.Len(); n++ {
+		if d := h.Ago(n); d != nil {
+			return d.close
+		}
+	}
+	return math.NaN()
+}
+
+// 今日が系列の何番目の日か (最初の日は0)
+func (c *BarContext) Index() int {
+	return c.i
+}
+
+// 過去の足の列
+// 元の系列を変更できないように、スライスを直接は見せない
+type History struct {
+	data []*DailyData
+}
+
+// 足の数
+func (h History) Len() int {
+	return len(h.data)
+}
+
+// n 日前の足 (1なら前日)。範囲外か、その日のデータがなければ nil
+func (h History) Ago(n int) *DailyData {
+	if n < 1 || n > len(h.data) {
+		return nil
+	}
+	return h.data[len(h.data)-n]
+}
+
+// 直近 n 日の終値 (古い順)。データがない日は飛ばす
+// n は0から持っている日数までに切り詰める
+func (h History) Closes(n int) []float64 {
+	if n > len(h.data) {
+		n = len(h.data)
+	}
+	if n < 0 {
+		n = 0
+	}
+	vs := []float64{}
+	for _, d := range h.data[len(h.data)-n:] {
+		if d != nil {
+			vs = append(vs, d.close)
+		}
+	}
+	return vs
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBarContext(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06", "2020-01-07")
+	gold := []*DailyData{nil, index[1], index[2], index[3]}
+	c := &BarContext{
+		Date:   index[2].date,
+		i:      2,
+		series: map[string][]*DailyData{SeriesIndex: index, "gold": gold},
+	}
+
+	assert.Equal(t, 3.0, c.Bar(SeriesIndex).Open())
+	assert.Nil(t, c.Bar(SeriesIV))
+
+	// 今日の足は履歴に含まない
+	h := c.History(SeriesIndex)
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, 2.0, h.Ago(1).Close())
+	assert.Equal(t, 1.0, h.Ago(2).Close())
+	assert.Nil(t, h.Ago(0))
+	assert.Nil(t, h.Ago(3))
+	assert.Equal(t, []float64{1, 2}, h.Closes(5))
+	assert.Equal(t, []float64{}, h.Closes(-1))
+
+	// データがない日は飛ばす
+	g := c.History("gold")
+	assert.Nil(t, g.Ago(2))
+	assert.Equal(t, []float64{2}, g.Closes(2))
+
+	assert.Equal(t, 0, c.History("oil").Len())
+}
+
+func TestBarContextAvailability(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06")
+	iv := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06")
+	nikkei := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06")
+	series := map[string][]*DailyData{SeriesIndex: index, SeriesIV: iv, "nikkei": nikkei}
+	c := &BarContext{i: 2, series: series, avail: map[string]Availability{
+		SeriesIV: {Open: 5, Close: sessionMinutes},
+		"nikkei": {Open: -900, Close: -30},
+	}}
+
+	// 寄り付きでは今日の始値しか分からない
+	b := c.Bar(SeriesIndex)
+	assert.Equal(t, 3.0, b.Open())
+	assert.True(t, math.IsNaN(b.Close()))
+	assert.True(t, math.IsNaN(b.High()))
+	assert.Equal(t, 3.0, c.Last(SeriesIndex))
+
+	// 寄り付きの後に始値が分かる系列は、前日の終値まで
+	assert.Nil(t, c.Bar(SeriesIV))
+	assert.Equal(t, 2.0, c.Last(SeriesIV))
+
+	// 寄り付きの前に引けた系列は、今日の終値まで分かる
+	assert.Equal(t, 3.0, c.Bar("nikkei").Close())
+
+	// 遅らせる日数の分だけ前の足を見る
+	c.avail[SeriesIV] = Availability{Close: sessionMinutes, Lag: 1}
+	assert.Equal(t, 2.0, c.Bar(SeriesIV).Close())
+	assert.Equal(t, 1, c.History(SeriesIV).Len())
+
+	c.i = 0
+	assert.Nil(t, c.Bar(SeriesIV))
+	assert.True(t, math.IsNaN(c.Last(SeriesIV)))
+
+	// 設定がなくても、iv の始値は寄り付きでは分からない
+	c = &BarContext{i: 2, series: series}
+	assert.Nil(t, c.Bar(SeriesIV))
+	assert.Equal(t, 2.0, c.Last(SeriesIV))
+	assert.Equal(t, 3.0, c.Last(SeriesIndex))
+}
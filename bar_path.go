@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// 日足の中での値動きの仮定
+// 日足には始値・高値・安値・終値しかないので、ロスカットや追証を判定する順番を決める
+type PathModelName string
+
+const (
+	// 始値 → 高値 → 安値 → 終値
+	PathOHLC PathModelName = "ohlc"
+	// 始値 → 安値 → 高値 → 終値
+	PathOLHC PathModelName = "olhc"
+	// 日ごとに ohlc と olhc のどちらかをランダムに選ぶ
+	PathRandom PathModelName = "random"
+	// 高値・安値を通るブラウン橋で日中の値動きを作る
+	PathBridge PathModelName = "bridge"
+)
+
+func ParsePathModelName(s string) (PathModelName, error) {
+	switch n := PathModelName(s); n {
+	case PathOHLC, PathOLHC, PathRandom, PathBridge:
+		return n, nil
+	}
+	return "", fmt.Errorf("unknown path model: %s", s)
+}
+
+// 日足から日中の値動きを作る
+type PathModel interface {
+	// 始値から終値までの価格の列。先頭は始値、末尾は終値で、高値と安値を必ず含む
+	Path(d *DailyData) []float64
+}
+
+// 名前からモデルを作る
+// seed は random, bridge の乱数の種、steps は bridge の1日の分割数
+func NewPathModel(name PathModelName, seed int64, steps int) (PathModel, error) {
+	switch name {
+	case PathOHLC:
+		return ohlcPath{}, nil
+	case PathOLHC:
+		return olhcPath{}, nil
+	case PathRandom:
+		return &randomPath{rand: rand.New(rand.NewSource(seed))}, nil
+	case PathBridge:
+		if steps < 4 {
+			return nil, fmt.Errorf("bridge path needs at least 4 steps: %d", steps)
+		}
+		return &bridgePath{rand: rand.New(rand.NewSource(seed)), steps: steps}, nil
+	}
+	return nil, fmt.Errorf("unknown path model: %s", name)
+}
+
+type ohlcPath struct{}
+
+func (ohlcPath) Path(d *DailyData) []float64 {
+	return []float64{d.open, d.high, d.low, d.close}
+}
+
+type olhcPath struct{}
+
+func (olhcPath) Path(d *DailyData) []float64 {
+	return []float64{d.open, d.low, d.high, d.close}
+}
+
+type randomPath struct {
+	rand *rand.Rand
+}
+
+func (p *randomPath) Path(d *DailyData) []float64 {
+	if p.rand.Intn(2) == 0 {
+		return ohlcPath{}.Path(d)
+	}
+	return olhcPath{}.Path(d)
+}
+
+// 高値と安値をつける時刻をランダムウォークの最大・最小の時刻から決め、
+// 始値・安値・高値・終値の間をブラウン橋でつなぐ
+// 値は高値と安値の範囲に収める
+type bridgePath struct {
+	rand  *rand.Rand
+	steps int
+}
+
+func (p *bridgePath) Path(d *DailyData) []float64 {
+	n := p.steps
+	// 高値・安値をつける時刻 (1 ~ n-1 の異なる値)
+	walk := 0.0
+	lowAt, highAt := 1, 2
+	minWalk, maxWalk := math.Inf(1), math.Inf(-1)
+	for i := 1; i < n; i++ {
+		walk += p.rand.NormFloat64()
+		if walk < minWalk {
+			minWalk, lowAt = walk, i
+		}
+		if walk > maxWalk {
+			maxWalk, highAt = walk, i
+		}
+	}
+	if lowAt == highAt {
+		// ランダムウォークの値がすべて等しいときだけ起きる
+		if highAt+1 < n {
+			highAt++
+		} else {
+			highAt--
+		}
+	}
+
+	type anchor struct {
+		at    int
+		price float64
+	}
+	anchors := []anchor{{0, d.open}, {lowAt, d.low}, {highAt, d.high}, {n, d.close}}
+	if highAt < lowAt {
+		anchors[1], anchors[2] = anchors[2], anchors[1]
+	}
+
+	// 1ステップあたりの標準偏差
+	sigma := (d.high - d.low) / math.Sqrt(float64(n))
+	path := make([]float64, n+1)
+	for k := 0; k+1 < len(anchors); k++ {
+		from, to := anchors[k], anchors[k+1]
+		path[from.at] = from.price
+		// from から to へのブラウン橋
+		w := 0.0
+		ws := make([]float64, to.at-from.at+1)
+		for i := 1; i < len(ws); i++ {
+			w += p.rand.NormFloat64() * sigma
+			ws[i] = w
+		}
+		span := float64(to.at - from.at)
+		for i := 1; i < len(ws)-1; i++ {
+			t := float64(i) / span
+			v := from.price + (to.price-from.price)*t + ws[i] - ws[len(ws)-1]*t
+			path[from.at+i] = math.Min(d.high, math.Max(d.low, v))
+		}
+		path[to.at] = to.price
+	}
+	return path
+}
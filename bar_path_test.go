@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedPath(t *testing.T) {
+	d := &DailyData{open: 100, high: 110, low: 90, close: 105}
+	assert.Equal(t, []float64{100, 110, 90, 105}, ohlcPath{}.Path(d))
+	assert.Equal(t, []float64{100, 90, 110, 105}, olhcPath{}.Path(d))
+}
+
+func TestRandomPath(t *testing.T) {
+	d := &DailyData{open: 100, high: 110, low: 90, close: 105}
+	m1, _ := NewPathModel(PathRandom, 1, 0)
+	m2, _ := NewPathModel(PathRandom, 1, 0)
+	seen := map[float64]bool{}
+	for i := 0; i < 20; i++ {
+		p := m1.Path(d)
+		assert.Equal(t, p, m2.Path(d))
+		seen[p[1]] = true
+	}
+	// 両方の順番が出る
+	assert.Equal(t, map[float64]bool{90: true, 110: true}, seen)
+}
+
+func TestBridgePath(t *testing.T) {
+	d := &DailyData{open: 100, high: 110, low: 90, close: 105}
+	m, err := NewPathModel(PathBridge, 1, 16)
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		p := m.Path(d)
+		assert.Equal(t, 17, len(p))
+		assert.Equal(t, 100.0, p[0])
+		assert.Equal(t, 105.0, p[16])
+		assert.Contains(t, p, 110.0)
+		assert.Contains(t, p, 90.0)
+		for _, v := range p {
+			assert.True(t, v >= 90 && v <= 110)
+		}
+	}
+
+	_, err = NewPathModel(PathBridge, 1, 2)
+	assert.Error(t, err)
+	_, err = ParsePathModelName("close")
+	assert.Error(t, err)
+}
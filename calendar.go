@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// 日付の標準の書式
+const dateLayout = "2006-01-02"
+
+func formatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// 日付を集計する単位
+type Period string
+
+const (
+	Week    Period = "week" // 月曜始まり
+	Month   Period = "month"
+	Quarter Period = "quarter"
+	Year    Period = "year"
+)
+
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case Week, Month, Quarter, Year:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown period: %s", s)
+}
+
+// 日付が属する期間の初日
+func (p Period) Start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch p {
+	case Week:
+		// time.Sunday が0なので、月曜始まりにずらす
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case Quarter:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case Year:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	panic("unknown period: " + string(p))
+}
+
+// 2つの日付が同じ期間に属するかどうか
+func (p Period) Same(a, b time.Time) bool {
+	return p.Start(a).Equal(p.Start(b))
+}
+
+// t の n 期間後の日付
+func (p Period) Add(t time.Time, n int) time.Time {
+	switch p {
+	case Week:
+		return t.AddDate(0, 0, 7*n)
+	case Month:
+		return t.AddDate(0, n, 0)
+	case Quarter:
+		return t.AddDate(0, 3*n, 0)
+	case Year:
+		return t.AddDate(n, 0, 0)
+	}
+	panic("unknown period: " + string(p))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestPeriodStart(t *testing.T) {
+	// 2020-01-01 は水曜日
+	assert.Equal(t, date("2019-12-30"), Week.Start(date("2020-01-01")))
+	assert.Equal(t, date("2019-12-30"), Week.Start(date("2020-01-05")))
+	assert.Equal(t, date("2020-01-06"), Week.Start(date("2020-01-06")))
+	assert.Equal(t, date("2020-02-01"), Month.Start(date("2020-02-29")))
+	assert.Equal(t, date("2020-04-01"), Quarter.Start(date("2020-06-30")))
+	assert.Equal(t, date("2020-10-01"), Quarter.Start(date("2020-10-01")))
+	assert.Equal(t, date("2020-01-01"), Year.Start(date("2020-12-31")))
+}
+
+func TestPeriodSame(t *testing.T) {
+	assert.True(t, Month.Same(date("2020-01-01"), date("2020-01-31")))
+	assert.False(t, Month.Same(date("2020-01-31"), date("2020-02-01")))
+	assert.False(t, Month.Same(date("2019-01-15"), date("2020-01-15")))
+	assert.True(t, Quarter.Same(date("2020-01-15"), date("2020-03-15")))
+	assert.False(t, Year.Same(date("2019-12-31"), date("2020-01-01")))
+}
+
+func TestPeriodAdd(t *testing.T) {
+	assert.Equal(t, date("2020-01-15"), Week.Add(date("2020-01-01"), 2))
+	assert.Equal(t, date("2020-03-01"), Month.Add(date("2020-01-01"), 2))
+	assert.Equal(t, date("2020-07-01"), Quarter.Add(date("2020-01-01"), 2))
+	assert.Equal(t, date("2025-01-01"), Year.Add(date("2020-01-01"), 5))
+}
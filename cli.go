@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// サブコマンド
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+func commands() []*command {
+	return []*command{
+		{name: "run", usage: "バックテストを実行する", run: runCommand},
+		{name: "retire", usage: "開始日をずらして出金計画の破綻確率を調べる", run: retireCommand},
+		{name: "sweep", usage: "戦略のパラメータの組み合わせを並列に試す", run: sweepCommand},
+		{name: "walkforward", usage: "期間をずらしながら最適化と期間外の検証を繰り返す", run: walkForwardCommand},
+		{name: "strategies", usage: "登録された戦略とパラメータを一覧する", run: strategiesCommand},
+	}
+}
+
+// コマンドライン引数を解釈して実行し、終了コードを返す
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return 2
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	for _, c := range commands() {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "cfd %s: %v\n", name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "cfd: unknown command %q\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: cfd <command> [flags]\n\nCommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(w, "\n`cfd <command> -h` で各コマンドのフラグを表示\n")
+}
+
+// run サブコマンド
+// -config で設定ファイルを読み込み、明示的に指定したフラグで上書きする
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	configPath := fs.String("config", "", "設定ファイル (JSON/YAML)")
+	indexPath := fs.String("index", "", "株価指数のヒストリカルデータ (CSV)")
+	ivPath := fs.String("iv", "", "ボラティリティインデックスのヒストリカルデータ (CSV)")
+	from := fs.String("from", "", "開始日 (YYYY-MM-DD, この日を含む)")
+	to := fs.String("to", "", "終了日 (YYYY-MM-DD, この日を含む)")
+	join := fs.String("join", string(JoinDropReport), "日付の揃え方 (inner, ffill-iv, drop-report)")
+	null := fs.String("null", string(NullSkip), "null の行の扱い (skip, repair, error)")
+	strategy := fs.String("strategy", "leverage-ratio", "戦略 (戦略/式 の形式。一覧は cfd strategies)")
+	params := paramFlag{}
+	fs.Var(params, "param", "戦略のパラメータ (name=value, 複数指定可)")
+	initial := fs.Float64("initial", 300.0, "初回入金額")
+	income := fs.Float64("income", 0.0, "毎月の入金額")
+	depositDay := fs.Int("deposit-day", 1, "毎月の入金日 (休場日なら次の営業日)")
+	growth := fs.Float64("growth", 0.0, "毎月の入金額の年あたりの伸び率")
+	path := fs.String("path", string(PathOHLC), "日中の値動きの仮定 (ohlc, olhc, random, bridge)")
+	seed := fs.Int64("seed", 1, "random, bridge の乱数の種")
+	format := fs.String("format", "text", "出力形式 (text, json)")
+	output := fs.String("output", "", "出力先のファイル (省略すると標準出力)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c := DefaultConfig()
+	if *configPath != "" {
+		var err error
+		c, err = LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var out *ReportOutput
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "index":
+			c.Data.Index = *indexPath
+		case "iv":
+			c.Data.IV = *ivPath
+		case "from":
+			c.Data.From = *from
+		case "to":
+			c.Data.To = *to
+		case "join":
+			c.Data.Join = *join
+		case "null":
+			c.Data.Null = *null
+		case "strategy":
+			if canonicalStrategyName(c.Strategy.Name) != canonicalStrategyName(*strategy) {
+				c.Strategy.Params = map[string]float64{}
+			}
+			c.Strategy.Name = *strategy
+		case "initial":
+			c.Deposit.Initial = *initial
+		case "income":
+			c.Deposit.Income = *income
+		case "deposit-day":
+			c.Deposit.Day = *depositDay
+		case "growth":
+			c.Deposit.GrowthRate = *growth
+		case "path":
+			c.Intraday.Path = *path
+		case "seed":
+			c.Intraday.Seed = *seed
+		case "format", "output":
+			out = &ReportOutput{Format: *format, Path: *output}
+		}
+	})
+	for k, v := range params {
+		if c.Strategy.Params == nil {
+			c.Strategy.Params = map[string]float64{}
+		}
+		c.Strategy.Params[k] = v
+	}
+	if out != nil {
+		c.Report.Outputs = []*ReportOutput{out}
+	}
+
+	r, err := runConfig(c)
+	if err != nil {
+		return err
+	}
+	return writeReports(c, r)
+}
+
+// retire サブコマンド
+func retireCommand(args []string) error {
+	fs := flag.NewFlagSet("retire", flag.ContinueOnError)
+	configPath := fs.String("config", "", "設定ファイル (JSON/YAML, withdrawal が必要)")
+	horizon := fs.Int("horizon", 30, "1回のシミュレーションの年数")
+	step := fs.String("step", string(Year), "開始日をずらす間隔 (month, year)")
+	format := fs.String("format", "text", "出力形式 (text, json)")
+	verbose := fs.Bool("v", false, "各バックテストのログを出力する")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("-config is required")
+	}
+	p, err := ParsePeriod(*step)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	c, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := c.normalize(); err != nil {
+		return err
+	}
+	index, iv, _, err := readData(c.Data)
+	if err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	rep, err := simulateRetirement(c, index, iv, *horizon, p)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return printRetirementReportJSON(os.Stdout, c, rep)
+	}
+	printRetirementReport(os.Stdout, rep)
+	return nil
+}
+
+// sweep サブコマンド
+// 途中経過は -output に .progress を付けたファイルに1行ずつ追記し、-resume で続きから実行する
+func sweepCommand(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	configPath := fs.String("config", "", "基準にする設定ファイル (JSON/YAML)")
+	strategy := fs.String("strategy", "", "戦略 (省略すると設定ファイルの戦略)")
+	ranges := rangeFlag{}
+	fs.Var(&ranges, "range", "試すパラメータ (name=from:to:step か name=v1,v2,...、複数指定可)")
+	workers := fs.Int("workers", runtime.NumCPU(), "同時に実行するバックテストの数")
+	format := fs.String("format", "csv", "出力形式 (csv, json)")
+	output := fs.String("output", "", "出力先のファイル (省略すると標準出力)")
+	sortKey := fs.String("sort", "cagr", "並べ替えに使う統計")
+	asc := fs.Bool("asc", false, "小さい順に並べる")
+	resume := fs.Bool("resume", false, "中断したスイープを続きから実行する")
+	verbose := fs.Bool("v", false, "各バックテストのログを出力する")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("-config is required")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+	if *resume && *output == "" {
+		return fmt.Errorf("-resume requires -output")
+	}
+
+	c, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *strategy != "" {
+		if canonicalStrategyName(c.Strategy.Name) != canonicalStrategyName(*strategy) {
+			c.Strategy.Params = map[string]float64{}
+		}
+		c.Strategy.Name = *strategy
+	}
+	if err := c.normalize(); err != nil {
+		return err
+	}
+	index, iv, _, err := readData(c.Data)
+	if err != nil {
+		return err
+	}
+	sw := &Sweep{Config: c, Index: index, IV: iv, Ranges: ranges, Workers: *workers}
+	// 範囲の誤りは実行前に知らせる
+	if _, err := sw.jobs(); err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	all := []*SweepResult{}
+	done := map[string]bool{}
+	var progress *os.File
+	if *output != "" {
+		path := *output + ".progress"
+		if *resume {
+			prev, err := readSweepProgress(path)
+			if err != nil {
+				return err
+			}
+			if all, done, err = sw.resume(prev); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		fp, err := sw.fingerprint()
+		if err != nil {
+			return err
+		}
+		// 残した結果だけで書き直してから追記する
+		if progress, err = os.Create(path); err != nil {
+			return err
+		}
+		defer progress.Close()
+		if err := writeSweepProgress(progress, &sweepProgress{Fingerprint: fp}); err != nil {
+			return err
+		}
+		for _, r := range all {
+			if err := writeSweepProgress(progress, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = sw.Run(done, func(r *SweepResult) error {
+		all = append(all, r)
+		if r.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", sweepKey(r.Strategy, r.Params), r.Error)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: cagr=%f\n", sweepKey(r.Strategy, r.Params), r.Metrics["cagr"])
+		}
+		if progress == nil {
+			return nil
+		}
+		return writeSweepProgress(progress, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sortSweepResults(all, *sortKey, *asc); err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeSweepResults(w, *format, all)
+}
+
+// walkforward サブコマンド
+func walkForwardCommand(args []string) error {
+	fs := flag.NewFlagSet("walkforward", flag.ContinueOnError)
+	configPath := fs.String("config", "", "基準にする設定ファイル (JSON/YAML)")
+	strategy := fs.String("strategy", "", "戦略 (省略すると設定ファイルの戦略)")
+	ranges := rangeFlag{}
+	fs.Var(&ranges, "range", "試すパラメータ (name=from:to:step か name=v1,v2,...、複数指定可)")
+	inSample := fs.Int("in-sample", 5, "最適化する期間の長さ (-unit の数)")
+	outOfSample := fs.Int("out-of-sample", 1, "検証する期間の長さ (-unit の数)。この長さずつずらす")
+	unit := fs.String("unit", string(Year), "期間の単位 (month, quarter, year)")
+	objective := fs.String("objective", "cagr", "最適化する統計")
+	asc := fs.Bool("asc", false, "小さいほど良い統計として最適化する")
+	workers := fs.Int("workers", runtime.NumCPU(), "同時に実行するバックテストの数")
+	format := fs.String("format", "text", "出力形式 (text, json)")
+	output := fs.String("output", "", "出力先のファイル (省略すると標準出力)")
+	verbose := fs.Bool("v", false, "各バックテストのログを出力する")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("-config is required")
+	}
+	u, err := ParsePeriod(*unit)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	c, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *strategy != "" {
+		if canonicalStrategyName(c.Strategy.Name) != canonicalStrategyName(*strategy) {
+			c.Strategy.Params = map[string]float64{}
+		}
+		c.Strategy.Name = *strategy
+	}
+	if err := c.normalize(); err != nil {
+		return err
+	}
+	index, iv, _, err := readData(c.Data)
+	if err != nil {
+		return err
+	}
+	sw := &Sweep{Config: c, Index: index, IV: iv, Ranges: ranges, Workers: *workers}
+	if _, err := sw.jobs(); err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	wf := &WalkForward{
+		Sweep:       sw,
+		InSample:    *inSample,
+		OutOfSample: *outOfSample,
+		Unit:        u,
+		Objective:   *objective,
+		Asc:         *asc,
+	}
+	rep, err := wf.Run(func(f *walkForwardFold) {
+		fmt.Fprintf(os.Stderr, "%s ~ %s: %s\n", f.OutOfSampleFrom, f.OutOfSampleTo, sweepKey(c.Strategy.Name, f.Params))
+	})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		return printWalkForwardReportJSON(w, c, rep)
+	}
+	printWalkForwardReport(w, rep)
+	return nil
+}
+
+// 結果かヘッダを1行追記する
+func writeSweepProgress(f *os.File, r interface{}) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// strategies サブコマンド
+func strategiesCommand(args []string) error {
+	fs := flag.NewFlagSet("strategies", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	printStrategies(os.Stdout)
+	return nil
+}
+
+func printStrategies(w io.Writer) {
+	aliases := map[string][]string{}
+	for alias, name := range strategyAliases {
+		aliases[name] = append(aliases[name], alias)
+	}
+	for _, n := range StrategyNames() {
+		e := strategyRegistry[n]
+		fmt.Fprintf(w, "%s", n)
+		if as := aliases[n]; len(as) != 0 {
+			sort.Strings(as)
+			fmt.Fprintf(w, " (%s)", strings.Join(as, ", "))
+		}
+		fmt.Fprintf(w, "\n  %s\n", e.Description)
+		for _, p := range e.Params {
+			fmt.Fprintf(w, "  -param %s=%v  %s, %s [%v, %v]\n", p.Name, p.Default, p.Description, p.Type, p.Min, p.Max)
+		}
+	}
+}
+
+// 複数指定できるパラメータの範囲のフラグ
+type rangeFlag []*ParamRange
+
+func (r *rangeFlag) String() string {
+	ns := []string{}
+	for _, p := range *r {
+		ns = append(ns, p.Name)
+	}
+	return strings.Join(ns, ",")
+}
+
+func (r *rangeFlag) Set(s string) error {
+	p, err := ParseParamRange(s)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, p)
+	return nil
+}
+
+// name=value 形式で複数指定できるフラグ
+type paramFlag map[string]float64
+
+func (p paramFlag) String() string {
+	kvs := []string{}
+	for k, v := range p {
+		kvs = append(kvs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func (p paramFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected name=value: %s", s)
+	}
+	v, err := strconv.ParseFloat(kv[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", kv[0], err)
+	}
+	p[kv[0]] = v
+	return nil
+}
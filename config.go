@@ -0,0 +1,818 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// バックテスト全体の設定
+// 設定ファイル (JSON/YAML) から読み込むか、コマンドライン引数から組み立てる
+type Config struct {
+	Data     DataConfig     `json:"data" yaml:"data"`
+	Strategy StrategyConfig `json:"strategy" yaml:"strategy"`
+	Deposit  DepositConfig  `json:"deposit" yaml:"deposit"`
+
+	Withdrawal WithdrawalConfig `json:"withdrawal" yaml:"withdrawal"`
+	Financing  FinancingConfig  `json:"financing" yaml:"financing"`
+	Dividend   DividendConfig   `json:"dividend" yaml:"dividend"`
+	Broker     BrokerConfig     `json:"broker" yaml:"broker"`
+	Contract   ContractConfig   `json:"contract" yaml:"contract"` // 株価指数の取引の決まり
+	Order      OrderConfig      `json:"order" yaml:"order"`
+	Currency   CurrencyConfig   `json:"currency" yaml:"currency"`
+
+	Instruments []*InstrumentConfig `json:"instruments" yaml:"instruments"`
+	Intraday    IntradayConfig      `json:"intraday" yaml:"intraday"`
+	// 系列ごとの、データが分かる時刻 (キーは index, iv と instruments の name)
+	Availability map[string]*AvailabilityConfig `json:"availability" yaml:"availability"`
+	MarginCall   MarginCallConfig               `json:"margin_call" yaml:"margin_call"`
+	Report       ReportConfig                   `json:"report" yaml:"report"`
+}
+
+// データソースと期間
+type DataConfig struct {
+	Index string `json:"index" yaml:"index"`         // 株価指数のCSV
+	IV    string `json:"iv" yaml:"iv"`               // ボラティリティインデックスのCSV
+	From  string `json:"from" yaml:"from"`           // YYYY-MM-DD, この日を含む
+	To    string `json:"to" yaml:"to"`               // YYYY-MM-DD, この日を含む
+	Join  string `json:"join" yaml:"join"`           // 日付の揃え方 (inner, ffill-iv, drop-report)
+	Null  string `json:"null_rows" yaml:"null_rows"` // null の行の扱い (skip, repair, error)
+	// CSVの日付の書式 (Go の time.Parse のレイアウト)。先頭から順に試す
+	DateLayouts []string `json:"date_layouts" yaml:"date_layouts"`
+}
+
+func (c DataConfig) csvOptions() CSVOptions {
+	return CSVOptions{Null: NullPolicy(c.Null), Layouts: c.DateLayouts}
+}
+
+// From, To を日付にする。空ならゼロ値
+func (c DataConfig) period() (from, to time.Time, err error) {
+	if c.From != "" {
+		if from, err = time.Parse(dateLayout, c.From); err != nil {
+			return from, to, fmt.Errorf("invalid data.from: %v", err)
+		}
+	}
+	if c.To != "" {
+		if to, err = time.Parse(dateLayout, c.To); err != nil {
+			return from, to, fmt.Errorf("invalid data.to: %v", err)
+		}
+	}
+	return from, to, nil
+}
+
+// 戦略とそのパラメータ
+type StrategyConfig struct {
+	Name   string             `json:"name" yaml:"name"`
+	Params map[string]float64 `json:"params" yaml:"params"`
+}
+
+// 入金スケジュール
+type DepositConfig struct {
+	Initial    float64          `json:"initial" yaml:"initial"`         // 初回入金額
+	Income     float64          `json:"income" yaml:"income"`           // 毎月の入金額
+	Day        int              `json:"day" yaml:"day"`                 // 毎月の入金日 (休場日なら次の営業日)
+	Bonus      map[int]float64  `json:"bonus" yaml:"bonus"`             // 月 (1~12) ごとの追加入金
+	GrowthRate float64          `json:"growth_rate" yaml:"growth_rate"` // 毎月の入金と追加入金の年あたりの伸び率
+	LumpSums   []*LumpSumConfig `json:"lump_sums" yaml:"lump_sums"`     // 一時金
+	TopUp      bool             `json:"top_up" yaml:"top_up"`           // 追証が発生したら不足額を入金する
+	MaxTopUp   float64          `json:"max_top_up" yaml:"max_top_up"`   // 追証1回あたりの入金の上限。0なら上限なし
+}
+
+type LumpSumConfig struct {
+	Date   string  `json:"date" yaml:"date"` // YYYY-MM-DD
+	Amount float64 `json:"amount" yaml:"amount"`
+}
+
+// 入金計画を作る
+// start は計画の開始日で、ゼロ値なら最初の営業日
+func (c DepositConfig) plan(start time.Time) (*DepositPlan, error) {
+	if c.Day < 1 || c.Day > 31 {
+		return nil, fmt.Errorf("deposit.day must be between 1 and 31: %d", c.Day)
+	}
+	p := &DepositPlan{
+		Start:      start,
+		Initial:    c.Initial,
+		Monthly:    c.Income,
+		Day:        c.Day,
+		Bonus:      map[time.Month]float64{},
+		GrowthRate: c.GrowthRate,
+		TopUp:      c.TopUp,
+		MaxTopUp:   c.MaxTopUp,
+	}
+	if c.MaxTopUp < 0 {
+		return nil, fmt.Errorf("deposit.max_top_up must not be negative: %f", c.MaxTopUp)
+	}
+	for m, v := range c.Bonus {
+		if m < 1 || m > 12 {
+			return nil, fmt.Errorf("invalid month in deposit.bonus: %d", m)
+		}
+		p.Bonus[time.Month(m)] = v
+	}
+	for _, l := range c.LumpSums {
+		d, err := time.Parse(dateLayout, l.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in deposit.lump_sums: %v", err)
+		}
+		p.LumpSums = append(p.LumpSums, &LumpSum{Date: d, Amount: l.Amount})
+	}
+	return p, nil
+}
+
+// 出金スケジュール
+type WithdrawalConfig struct {
+	Rule              string  `json:"rule" yaml:"rule"`                             // 空なら出金しない (fixed, percent, guardrail)
+	Frequency         string  `json:"frequency" yaml:"frequency"`                   // 出金の間隔 (month, year)
+	Start             string  `json:"start" yaml:"start"`                           // YYYY-MM-DD。空なら最初の営業日から
+	Amount            float64 `json:"amount" yaml:"amount"`                         // fixed, guardrail での1回あたりの出金額
+	Rate              float64 `json:"rate" yaml:"rate"`                             // percent での評価額に対する割合
+	DrawdownThreshold float64 `json:"drawdown_threshold" yaml:"drawdown_threshold"` // guardrail で出金額を減らす下落率
+	Cut               float64 `json:"cut" yaml:"cut"`                               // guardrail で出金額を減らす割合
+	AllowClose        bool    `json:"allow_close" yaml:"allow_close"`               // 余力が足りなければ決済して出金する
+}
+
+// 出金計画を作る。出金しないなら nil
+func (c WithdrawalConfig) plan() (*WithdrawalPlan, error) {
+	if c.Rule == "" {
+		return nil, nil
+	}
+	rule, err := ParseWithdrawalRule(c.Rule)
+	if err != nil {
+		return nil, err
+	}
+	freq, err := ParsePeriod(c.Frequency)
+	if err != nil {
+		return nil, err
+	}
+	if freq != Month && freq != Year {
+		return nil, fmt.Errorf("withdrawal.frequency must be month or year: %s", freq)
+	}
+	p := &WithdrawalPlan{
+		Rule:              rule,
+		Frequency:         freq,
+		Amount:            c.Amount,
+		Rate:              c.Rate,
+		DrawdownThreshold: c.DrawdownThreshold,
+		Cut:               c.Cut,
+		AllowClose:        c.AllowClose,
+	}
+	if c.Start != "" {
+		if p.Start, err = time.Parse(dateLayout, c.Start); err != nil {
+			return nil, fmt.Errorf("invalid withdrawal.start: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// 金利調整額
+// rate, rate_csv, markup がすべて省略されていれば金利調整額はかからない
+type FinancingConfig struct {
+	Rate        float64 `json:"rate" yaml:"rate"`                 // 固定の年率 (0.01 なら1%)
+	RateCSV     string  `json:"rate_csv" yaml:"rate_csv"`         // 日付ごとの年率のCSV。指定すれば rate より優先
+	RateColumn  string  `json:"rate_column" yaml:"rate_column"`   // rate_csv の値の列名。空なら2列目
+	RatePercent bool    `json:"rate_percent" yaml:"rate_percent"` // rate_csv の値が % 表記かどうか
+	Markup      float64 `json:"markup" yaml:"markup"`             // 業者の上乗せ分の年率
+	DayCount    float64 `json:"day_count" yaml:"day_count"`       // 1年の日数
+}
+
+func (c FinancingConfig) enabled() bool {
+	return c.Rate != 0 || c.RateCSV != "" || c.Markup != 0
+}
+
+// rate_csv の金利を読み込む。金利調整額がかからないか、rate_csv がなければ nil
+func (c FinancingConfig) rateSeries(layouts []string) (*RateSeries, error) {
+	if !c.enabled() || c.RateCSV == "" {
+		return nil, nil
+	}
+	s, err := ReadRateSeries(c.RateCSV, c.RateColumn, c.RatePercent, layouts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read rate csv: %v", err)
+	}
+	return s, nil
+}
+
+// 金利調整額のモデルを作る。かからないなら nil
+// rates は rateSeries で読み込んだ rate_csv の金利
+func (c FinancingConfig) model(rates *RateSeries) (*FinancingModel, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	if c.DayCount <= 0 {
+		return nil, fmt.Errorf("financing.day_count must be positive: %v", c.DayCount)
+	}
+	var rate RateSource = FixedRate(c.Rate)
+	if rates != nil {
+		rate = rates
+	}
+	return &FinancingModel{
+		Rate:     rate,
+		Markup:   c.Markup,
+		DayCount: c.DayCount,
+	}, nil
+}
+
+// 配当相当額 (価格調整額)
+// csv と total_return がどちらも省略されていれば配当相当額はない
+type DividendConfig struct {
+	CSV         string  `json:"csv" yaml:"csv"`                   // 権利落ち日と配当のCSV
+	Column      string  `json:"column" yaml:"column"`             // csv の配当の列名。空なら2列目
+	TotalReturn string  `json:"total_return" yaml:"total_return"` // 株価指数のトータルリターン版のCSV。配当を推定する
+	MinRatio    float64 `json:"min_ratio" yaml:"min_ratio"`       // 推定した配当が価格に対してこれ未満なら無視する
+}
+
+// csv の配当か total_return の株価指数を読み込む。なければ nil
+func (c DividendConfig) read(opts CSVOptions) (*DividendSchedule, []*DailyData, error) {
+	switch {
+	case c.CSV != "" && c.TotalReturn != "":
+		return nil, nil, fmt.Errorf("dividend.csv and dividend.total_return are exclusive")
+	case c.CSV != "":
+		s, err := ReadDividendSchedule(c.CSV, c.Column, opts.Layouts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read dividend csv: %v", err)
+		}
+		return s, nil, nil
+	case c.TotalReturn != "":
+		tr, err := ReadDailyDataWithOptions(c.TotalReturn, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read total return csv: %v", err)
+		}
+		return nil, tr, nil
+	}
+	return nil, nil, nil
+}
+
+// 配当の予定を作る。配当相当額がないなら nil
+// s と tr は read で読み込んだもの。推定には株価指数のヒストリカルデータを使う
+func (c DividendConfig) schedule(index []*DailyData, s *DividendSchedule, tr []*DailyData) (*DividendSchedule, error) {
+	if tr != nil {
+		return EstimateDividends(index, tr, c.MinRatio)
+	}
+	return s, nil
+}
+
+// 取引コストのモデルと口座の設定
+// プリセットの値を、指定した項目だけ上書きする
+type BrokerConfig struct {
+	Book           string   `json:"book,omitempty" yaml:"book,omitempty"` // 買いと売りの持ち方 (hedged, net)
+	Preset         string   `json:"preset" yaml:"preset"`
+	SpreadPoints   *float64 `json:"spread_points" yaml:"spread_points"`     // 固定のスプレッド
+	SpreadRate     *float64 `json:"spread_rate" yaml:"spread_rate"`         // 仲値に対する割合のスプレッド
+	VolBase        *float64 `json:"vol_base" yaml:"vol_base"`               // IVがこれを超えるとスプレッドが広がる
+	VolSlope       *float64 `json:"vol_slope" yaml:"vol_slope"`             // IVが1超えるごとにスプレッドが広がる割合
+	Commission     *float64 `json:"commission" yaml:"commission"`           // 1取引あたりの固定の手数料
+	CommissionRate *float64 `json:"commission_rate" yaml:"commission_rate"` // 約定代金に対する割合の手数料
+	SlippagePoints *float64 `json:"slippage_points" yaml:"slippage_points"` // 固定の値幅のスリッページ
+	SlippageRate   *float64 `json:"slippage_rate" yaml:"slippage_rate"`     // 仲値に対する割合のスリッページ
+	// 窓を開けてロスカット値を割ったとき、始値からさらに滑る幅
+	GapSlippagePoints *float64 `json:"gap_slippage_points" yaml:"gap_slippage_points"`
+	GapSlippageRate   *float64 `json:"gap_slippage_rate" yaml:"gap_slippage_rate"`
+}
+
+// コストモデルを作る
+func (c BrokerConfig) costModel() (*BasicCostModel, error) {
+	m, err := NewCostModelPreset(c.Preset)
+	if err != nil {
+		return nil, err
+	}
+	override := func(dst *float64, v *float64) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	override(&m.SpreadPoints, c.SpreadPoints)
+	override(&m.SpreadRate, c.SpreadRate)
+	override(&m.VolBase, c.VolBase)
+	override(&m.VolSlope, c.VolSlope)
+	override(&m.CommissionFixed, c.Commission)
+	override(&m.CommissionRate, c.CommissionRate)
+	override(&m.SlippagePoints, c.SlippagePoints)
+	override(&m.SlippageRate, c.SlippageRate)
+	override(&m.GapSlippagePoints, c.GapSlippagePoints)
+	override(&m.GapSlippageRate, c.GapSlippageRate)
+	return m, nil
+}
+
+// プリセットから決まる値も含めて、すべての項目を埋める
+func (c *BrokerConfig) fill(m *BasicCostModel) {
+	f := func(v float64) *float64 {
+		return &v
+	}
+	c.SpreadPoints = f(m.SpreadPoints)
+	c.SpreadRate = f(m.SpreadRate)
+	c.VolBase = f(m.VolBase)
+	c.VolSlope = f(m.VolSlope)
+	c.Commission = f(m.CommissionFixed)
+	c.CommissionRate = f(m.CommissionRate)
+	c.SlippagePoints = f(m.SlippagePoints)
+	c.SlippageRate = f(m.SlippageRate)
+	c.GapSlippagePoints = f(m.GapSlippagePoints)
+	c.GapSlippageRate = f(m.GapSlippageRate)
+}
+
+// 取引の決まり
+// プリセットの値を、指定した項目だけ上書きする
+type ContractConfig struct {
+	Preset      string   `json:"preset" yaml:"preset"`
+	MarginRatio *float64 `json:"margin_ratio" yaml:"margin_ratio"` // 必要証拠金の約定代金に対する割合
+	LosscutRate *float64 `json:"losscut_rate" yaml:"losscut_rate"` // ロスカット幅の建単価に対する割合
+	LotMin      *float64 `json:"lot_min" yaml:"lot_min"`           // 最小の取引数量
+	LotStep     *float64 `json:"lot_step" yaml:"lot_step"`         // 取引数量の刻み
+	Tick        *float64 `json:"tick" yaml:"tick"`                 // 呼値の単位。0なら丸めない
+	Multiplier  *float64 `json:"multiplier" yaml:"multiplier"`     // 1単位の約定代金の価格に対する倍率
+	MaxLeverage *float64 `json:"max_leverage" yaml:"max_leverage"` // レバレッジの上限
+}
+
+// 取引の決まりを作る
+func (c ContractConfig) spec() (*ContractSpec, error) {
+	s, err := NewContractSpecPreset(c.Preset)
+	if err != nil {
+		return nil, err
+	}
+	override := func(dst *float64, v *float64) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	override(&s.MarginRatio, c.MarginRatio)
+	override(&s.LosscutRate, c.LosscutRate)
+	override(&s.LotMin, c.LotMin)
+	override(&s.LotStep, c.LotStep)
+	override(&s.Tick, c.Tick)
+	override(&s.Multiplier, c.Multiplier)
+	override(&s.MaxLeverage, c.MaxLeverage)
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// プリセットから決まる値も含めて、すべての項目を埋める
+func (c *ContractConfig) fill(s *ContractSpec) {
+	f := func(v float64) *float64 {
+		return &v
+	}
+	c.MarginRatio = f(s.MarginRatio)
+	c.LosscutRate = f(s.LosscutRate)
+	c.LotMin = f(s.LotMin)
+	c.LotStep = f(s.LotStep)
+	c.Tick = f(s.Tick)
+	c.Multiplier = f(s.Multiplier)
+	c.MaxLeverage = f(s.MaxLeverage)
+}
+
+// 省略された値を補完して検証する
+// name はエラーメッセージに使う設定の場所
+func (c *ContractConfig) normalize(name string) error {
+	if c.Preset == "" {
+		c.Preset = "index-cfd"
+	}
+	s, err := c.spec()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	c.fill(s)
+	return nil
+}
+
+// 株価指数の1回の注文の大きさ
+// quantity と amount のどちらかを指定する。どちらも省略すれば数量1
+type OrderConfig struct {
+	Quantity float64 `json:"quantity" yaml:"quantity"` // 数量
+	Amount   float64 `json:"amount" yaml:"amount"`     // 約定代金。数量は取引単位に切り捨てる
+}
+
+func (c OrderConfig) size() OrderSize {
+	return OrderSize{Quantity: c.Quantity, Amount: c.Amount}
+}
+
+// spec の取引単位で注文できるか確認する
+func (c OrderConfig) validate(spec *ContractSpec) error {
+	switch {
+	case c.Quantity < 0 || c.Amount < 0:
+		return fmt.Errorf("order.quantity and order.amount must not be negative")
+	case c.Quantity != 0 && c.Amount != 0:
+		return fmt.Errorf("order.quantity and order.amount are exclusive")
+	case c.Quantity != 0 && spec.RoundLot(c.Quantity) == 0:
+		return fmt.Errorf("order.quantity is less than contract.lot_min: %v", c.Quantity)
+	}
+	return nil
+}
+
+// 口座の通貨と為替レート
+// account を省略すると通貨を区別しない
+type CurrencyConfig struct {
+	Account string                   `json:"account" yaml:"account"` // 口座の通貨
+	Index   string                   `json:"index" yaml:"index"`     // 株価指数の通貨。空なら口座の通貨
+	Rates   map[string]*FXRateConfig `json:"rates" yaml:"rates"`     // 口座と違う通貨ごとの為替レート
+}
+
+// 為替レートの系列
+type FXRateConfig struct {
+	CSV    string `json:"csv" yaml:"csv"`       // 口座通貨での、その通貨1単位の価格のCSV
+	Column string `json:"column" yaml:"column"` // 値の列名。空なら2列目
+	Invert bool   `json:"invert" yaml:"invert"` // 値の逆数を使う
+}
+
+// 省略された値を補完して検証する
+// currencies は銘柄の通貨 (空なら口座の通貨) で、空のものは口座の通貨で埋める
+func (c *CurrencyConfig) normalize(currencies ...*string) error {
+	if c.Account == "" {
+		if c.Index != "" || len(c.Rates) != 0 {
+			return fmt.Errorf("currency.account is required")
+		}
+		for _, cur := range currencies {
+			if *cur != "" {
+				return fmt.Errorf("currency.account is required")
+			}
+		}
+		return nil
+	}
+	for _, cur := range append(currencies, &c.Index) {
+		if *cur == "" {
+			*cur = c.Account
+		}
+		if *cur == c.Account {
+			continue
+		}
+		if r, ok := c.Rates[*cur]; !ok || r == nil || r.CSV == "" {
+			return fmt.Errorf("currency.rates.%s.csv is required", *cur)
+		}
+	}
+	return nil
+}
+
+// 口座の通貨と違う通貨 cur の為替レートを読み込む
+func (c CurrencyConfig) rates(cur string, layouts []string) (*FXRates, error) {
+	r := c.Rates[cur]
+	s, err := ReadFXRates(r.CSV, r.Column, r.Invert, layouts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s rate csv: %v", cur, err)
+	}
+	return s, nil
+}
+
+// 株価指数のほかに、同じ口座で持つ銘柄
+// 現金と証拠金は株価指数と共有する
+type InstrumentConfig struct {
+	Name     string         `json:"name" yaml:"name"`
+	Currency string         `json:"currency" yaml:"currency"` // 価格の通貨。空なら口座の通貨
+	CSV      string         `json:"csv" yaml:"csv"`           // 価格のCSV。株価指数の日付に揃える
+	Broker   BrokerConfig   `json:"broker" yaml:"broker"`     // 取引コスト (book は口座の設定を使う)
+	Contract ContractConfig `json:"contract" yaml:"contract"` // 取引の決まり
+}
+
+// 銘柄を作る
+func (c *InstrumentConfig) instrument() (*Instrument, error) {
+	cost, err := c.Broker.costModel()
+	if err != nil {
+		return nil, fmt.Errorf("instruments.%s: %v", c.Name, err)
+	}
+	spec, err := c.Contract.spec()
+	if err != nil {
+		return nil, fmt.Errorf("instruments.%s.contract: %v", c.Name, err)
+	}
+	return &Instrument{Name: c.Name, Currency: c.Currency, Cost: cost, Spec: spec}, nil
+}
+
+// 省略された値を補完して検証する
+func (c *InstrumentConfig) normalize(names map[string]bool) error {
+	if c.Name == "" || c.CSV == "" {
+		return fmt.Errorf("instruments: name and csv are required")
+	}
+	if names[c.Name] {
+		return fmt.Errorf("duplicate instrument: %s", c.Name)
+	}
+	names[c.Name] = true
+	if c.Broker.Preset == "" {
+		c.Broker.Preset = "gmo-click"
+	}
+	cost, err := c.Broker.costModel()
+	if err != nil {
+		return fmt.Errorf("instruments.%s: %v", c.Name, err)
+	}
+	c.Broker.Book = ""
+	c.Broker.fill(cost)
+	return c.Contract.normalize(fmt.Sprintf("instruments.%s.contract", c.Name))
+}
+
+// 日中の値動きの仮定
+// ロスカットと追証はこの値動きに沿って判定する
+type IntradayConfig struct {
+	Path  string `json:"path" yaml:"path"`   // ohlc, olhc, random, bridge
+	Seed  int64  `json:"seed" yaml:"seed"`   // random, bridge の乱数の種
+	Steps int    `json:"steps" yaml:"steps"` // bridge の1日の分割数
+}
+
+func (c IntradayConfig) model() (PathModel, error) {
+	name, err := ParsePathModelName(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPathModel(name, c.Seed, c.Steps)
+}
+
+// 系列のデータが分かる時刻
+// 時刻は株価指数の寄り付きからの分。戦略は寄り付きで判断し、それまでに分かったデータだけを見る
+type AvailabilityConfig struct {
+	Open  *float64 `json:"open" yaml:"open"`   // 始値が分かる時刻。省略すると0 (iv は1)
+	Close *float64 `json:"close" yaml:"close"` // 高値・安値・終値が分かる時刻。省略すると大引け (390)
+	Lag   int      `json:"lag" yaml:"lag"`     // 戦略に見せるのを遅らせる日数
+}
+
+// 省略された値を補完して検証する
+func (c *AvailabilityConfig) normalize(name string) error {
+	d := DefaultAvailability(name)
+	if c.Open == nil {
+		c.Open = &d.Open
+	}
+	if c.Close == nil {
+		c.Close = &d.Close
+	}
+	if *c.Close < *c.Open {
+		return fmt.Errorf("availability.%s: close must not be before open: %v < %v", name, *c.Close, *c.Open)
+	}
+	if c.Lag < 0 {
+		return fmt.Errorf("availability.%s.lag must not be negative: %d", name, c.Lag)
+	}
+	return nil
+}
+
+// 系列ごとのデータが分かる時刻
+// 設定ファイルにない系列は DefaultAvailability
+func availabilities(cs map[string]*AvailabilityConfig) map[string]Availability {
+	m := map[string]Availability{}
+	for name, c := range cs {
+		m[name] = Availability{Open: *c.Open, Close: *c.Close, Lag: c.Lag}
+	}
+	return m
+}
+
+// 追証の扱い
+type MarginCallConfig struct {
+	Mode      string `json:"mode" yaml:"mode"`             // immediate, deadline
+	GraceDays int    `json:"grace_days" yaml:"grace_days"` // deadline で、発生から強制決済までの営業日数
+	Reduce    bool   `json:"reduce" yaml:"reduce"`         // deadline で、発生の翌営業日に不足が解消するまで一部を決済する
+	// 強制決済の方針 (all, worst-first, largest-margin)
+	Liquidation string `json:"liquidation" yaml:"liquidation"`
+}
+
+// 結果の出力先
+type ReportConfig struct {
+	Outputs []*ReportOutput `json:"outputs" yaml:"outputs"`
+}
+
+type ReportOutput struct {
+	Format string `json:"format" yaml:"format"` // text, json
+	Path   string `json:"path" yaml:"path"`     // 空なら標準出力
+}
+
+// デフォルトの設定
+func DefaultConfig() *Config {
+	return &Config{
+		Data: DataConfig{
+			Join: string(JoinDropReport),
+			Null: string(NullSkip),
+
+			DateLayouts: DefaultCSVOptions().Layouts,
+		},
+		Strategy: StrategyConfig{
+			Name:   "leverage-ratio",
+			Params: map[string]float64{},
+		},
+		Deposit: DepositConfig{
+			Initial: 300.0,
+			Income:  0.0,
+			Day:     1,
+		},
+		Withdrawal: WithdrawalConfig{
+			Frequency: string(Month),
+		},
+		Financing: FinancingConfig{
+			DayCount: 365,
+		},
+		Dividend: DividendConfig{
+			MinRatio: 0.00005,
+		},
+		Broker: BrokerConfig{
+			Book:   string(BookHedged),
+			Preset: "gmo-click",
+		},
+		Contract: ContractConfig{
+			Preset: "index-cfd",
+		},
+		Intraday: IntradayConfig{
+			Path:  string(PathOHLC),
+			Seed:  1,
+			Steps: 16,
+		},
+		MarginCall: MarginCallConfig{
+			Mode:        string(MarginCallImmediate),
+			GraceDays:   1,
+			Liquidation: string(LiquidateAll),
+		},
+	}
+}
+
+// 設定ファイルを読み込む
+// 拡張子が .json なら JSON、.yaml/.yml なら YAML として扱う
+// 相対パスは設定ファイルのあるディレクトリからのパスとして解決する
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	// 知らないキーは、書き間違いや名前の変わったキーが黙って既定値になるのを防ぐためエラーにする
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(c)
+	case ".yaml", ".yml":
+		if err = checkYAMLKeys(b); err != nil {
+			break
+		}
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+		err = d.Decode(c)
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown config format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	c.resolvePaths(filepath.Dir(path))
+	return c, nil
+}
+
+// YAML のキーに null (~ や空) がないか調べる
+// null のキーは知らないキーとしても扱われず、黙って無視されるため
+func checkYAMLKeys(b []byte) error {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	var check func(n *yaml.Node) error
+	check = func(n *yaml.Node) error {
+		for i, m := range n.Content {
+			if n.Kind == yaml.MappingNode && i%2 == 0 && m.ShortTag() == "!!null" {
+				return fmt.Errorf("line %d: null key %q is not allowed (quote it if it is a name)", m.Line, m.Value)
+			}
+			if err := check(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(&n)
+}
+
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	c.Data.Index = resolve(c.Data.Index)
+	c.Data.IV = resolve(c.Data.IV)
+	c.Financing.RateCSV = resolve(c.Financing.RateCSV)
+	c.Dividend.CSV = resolve(c.Dividend.CSV)
+	c.Dividend.TotalReturn = resolve(c.Dividend.TotalReturn)
+	for _, in := range c.Instruments {
+		in.CSV = resolve(in.CSV)
+	}
+	for _, r := range c.Currency.Rates {
+		if r != nil {
+			r.CSV = resolve(r.CSV)
+		}
+	}
+	for _, o := range c.Report.Outputs {
+		o.Path = resolve(o.Path)
+	}
+}
+
+// 設定の検証と、省略された値の補完
+// 補完後の設定がそのまま結果に埋め込まれる
+func (c *Config) normalize() error {
+	if c.Data.Index == "" || c.Data.IV == "" {
+		return fmt.Errorf("data.index and data.iv are required")
+	}
+	if _, err := ParseJoinPolicy(c.Data.Join); err != nil {
+		return err
+	}
+	if _, err := ParseNullPolicy(c.Data.Null); err != nil {
+		return err
+	}
+	from, _, err := c.Data.period()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Deposit.plan(from); err != nil {
+		return err
+	}
+	if _, err := c.Withdrawal.plan(); err != nil {
+		return err
+	}
+	if len(c.Data.DateLayouts) == 0 {
+		c.Data.DateLayouts = DefaultCSVOptions().Layouts
+	}
+	m, err := c.Broker.costModel()
+	if err != nil {
+		return err
+	}
+	c.Broker.fill(m)
+	if _, err := ParseBookMode(c.Broker.Book); err != nil {
+		return err
+	}
+	if err := c.Contract.normalize("contract"); err != nil {
+		return err
+	}
+	spec, err := c.Contract.spec()
+	if err != nil {
+		return err
+	}
+	if c.Order.Quantity == 0 && c.Order.Amount == 0 {
+		c.Order.Quantity = 1
+	}
+	if err := c.Order.validate(spec); err != nil {
+		return err
+	}
+	names := map[string]bool{SeriesIndex: true, SeriesIV: true}
+	for _, in := range c.Instruments {
+		if err := in.normalize(names); err != nil {
+			return err
+		}
+	}
+	currencies := []*string{}
+	for _, in := range c.Instruments {
+		currencies = append(currencies, &in.Currency)
+	}
+	if err := c.Currency.normalize(currencies...); err != nil {
+		return err
+	}
+	if _, err := c.Intraday.model(); err != nil {
+		return err
+	}
+	series := []string{}
+	for name := range c.Availability {
+		series = append(series, name)
+	}
+	sort.Strings(series)
+	for _, name := range series {
+		if !names[name] {
+			return fmt.Errorf("availability: unknown series: %s", name)
+		}
+		if c.Availability[name] == nil {
+			c.Availability[name] = &AvailabilityConfig{}
+		}
+		if err := c.Availability[name].normalize(name); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseMarginCallMode(c.MarginCall.Mode); err != nil {
+		return err
+	}
+	if c.MarginCall.GraceDays < 1 {
+		return fmt.Errorf("margin_call.grace_days must be positive: %d", c.MarginCall.GraceDays)
+	}
+	if _, err := ParseLiquidationPolicy(c.MarginCall.Liquidation); err != nil {
+		return err
+	}
+	if len(c.Report.Outputs) == 0 {
+		c.Report.Outputs = []*ReportOutput{{Format: "text"}}
+	}
+	for _, o := range c.Report.Outputs {
+		if o.Format != "text" && o.Format != "json" {
+			return fmt.Errorf("unknown report format: %s", o.Format)
+		}
+	}
+	e, err := LookupStrategy(c.Strategy.Name)
+	if err != nil {
+		return err
+	}
+	params, err := e.normalizeParams(c.Strategy.Params)
+	if err != nil {
+		return err
+	}
+	// 別名で指定されても、実際に使う戦略の名前を残す
+	c.Strategy.Name = e.Name
+	c.Strategy.Params = params
+	return nil
+}
+
+// 設定から戦略を作る
+// パラメータは normalize 済みであること
+func newStrategy(c StrategyConfig) (Strategy, error) {
+	return NewStrategyByName(c.Name, c.Params)
+}
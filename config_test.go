@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	load := func(name, body string) (*Config, error) {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(body), 0644))
+		return LoadConfig(path)
+	}
+
+	c, err := load("ok.yaml", "data:\n  null_rows: error\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "error", c.Data.Null)
+	c, err = load("ok.json", `{"data": {"null_rows": "error"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", c.Data.Null)
+	_, err = load("empty.yaml", "")
+	assert.NoError(t, err)
+
+	// 古いキーや書き間違いは既定値にせずエラーにする
+	_, err = load("null.yaml", "data:\n  null: error\n")
+	assert.Error(t, err)
+	_, err = load("null.json", `{"data": {"null": "error"}}`)
+	assert.Error(t, err)
+	_, err = load("typo.yaml", "data:\n  nul_rows: error\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 銘柄の取引の決まり
+type ContractSpec struct {
+	MarginRatio float64 // 必要証拠金の約定代金に対する割合
+	LosscutRate float64 // ロスカット幅の建単価に対する割合
+	LotMin      float64 // 最小の取引数量
+	LotStep     float64 // 取引数量の刻み
+	Tick        float64 // 呼値の単位。0なら丸めない
+	Multiplier  float64 // 1単位の約定代金は価格のこの倍
+	MaxLeverage float64 // 設定できるレバレッジの上限
+}
+
+// 株価指数CFDの決まり
+// 必要証拠金は約定代金の10% (レバレッジ10倍)、ロスカット幅はおおよそ建単価の5%
+func DefaultContractSpec() *ContractSpec {
+	s, _ := NewContractSpecPreset("index-cfd")
+	return s
+}
+
+// 取引の決まりのプリセット
+var contractSpecPresets = map[string]func() *ContractSpec{
+	// 株価指数CFD。GMOクリック証券が決める値だが、ロスカット幅はおおよそ建単価の5%になる
+	"index-cfd": func() *ContractSpec {
+		return &ContractSpec{MarginRatio: 0.1, LosscutRate: 0.05, LotMin: 1, LotStep: 1, Multiplier: 1, MaxLeverage: 10}
+	},
+	// 商品CFD (金、原油など)。レバレッジ20倍
+	"commodity-cfd": func() *ContractSpec {
+		return &ContractSpec{MarginRatio: 0.05, LosscutRate: 0.025, LotMin: 1, LotStep: 1, Multiplier: 1, MaxLeverage: 20}
+	},
+	// 国内の店頭FX。レバレッジ25倍、1000通貨単位
+	"fx": func() *ContractSpec {
+		return &ContractSpec{MarginRatio: 0.04, LosscutRate: 0.02, LotMin: 1000, LotStep: 1000, Multiplier: 1, MaxLeverage: 25}
+	},
+	// 国内株式の信用取引。委託保証金率30%、維持率20%を割ると決済、100株単位
+	"stock-margin-jp": func() *ContractSpec {
+		return &ContractSpec{MarginRatio: 0.3, LosscutRate: 0.1, LotMin: 100, LotStep: 100, Tick: 1, Multiplier: 1, MaxLeverage: 1 / 0.3}
+	},
+}
+
+// プリセットの名前 (名前順)
+func contractSpecPresetNames() []string {
+	ns := []string{}
+	for n := range contractSpecPresets {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// 名前からプリセットの決まりを作る
+func NewContractSpecPreset(name string) (*ContractSpec, error) {
+	f, ok := contractSpecPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown contract preset: %s (available: %v)", name, contractSpecPresetNames())
+	}
+	return f(), nil
+}
+
+// 値が矛盾していないか確認する
+func (s *ContractSpec) Validate() error {
+	switch {
+	case s.MarginRatio <= 0 || s.MarginRatio > 1:
+		return fmt.Errorf("margin_ratio must be in (0, 1]: %v", s.MarginRatio)
+	case s.LosscutRate <= 0 || s.LosscutRate >= s.MarginRatio:
+		return fmt.Errorf("losscut_rate must be in (0, margin_ratio): %v", s.LosscutRate)
+	case s.LotMin <= 0 || s.LotStep <= 0:
+		return fmt.Errorf("lot_min and lot_step must be positive: %v, %v", s.LotMin, s.LotStep)
+	case s.Tick < 0:
+		return fmt.Errorf("tick must not be negative: %v", s.Tick)
+	case s.Multiplier <= 0:
+		return fmt.Errorf("multiplier must be positive: %v", s.Multiplier)
+	case s.MaxLeverage < 1 || s.MaxLeverage > 1/s.MarginRatio+1e-9:
+		return fmt.Errorf("max_leverage must be in [1, 1/margin_ratio]: %v", s.MaxLeverage)
+	}
+	return nil
+}
+
+// レバレッジを 1 ~ MaxLeverage に収める
+func (s *ContractSpec) clampLeverage(l float64) float64 {
+	return math.Min(math.Max(l, 1), s.MaxLeverage)
+}
+
+// 取引できる数量に切り捨てる。最小の取引数量に満たなければ0
+func (s *ContractSpec) RoundLot(q float64) float64 {
+	if q < s.LotMin {
+		return 0
+	}
+	// 浮動小数点の誤差で1刻み少なくならないようにし、結果の端数も落とす
+	n := math.Floor((q-s.LotMin)/s.LotStep + 1e-9)
+	return math.Round((s.LotMin+n*s.LotStep)*1e9) / 1e9
+}
+
+// 買うときの約定値を呼値の単位に切り上げる
+func (s *ContractSpec) ceilTick(price float64) float64 {
+	if s.Tick == 0 {
+		return price
+	}
+	return math.Ceil(price/s.Tick-1e-9) * s.Tick
+}
+
+// 売るときの約定値を呼値の単位に切り捨てる
+func (s *ContractSpec) floorTick(price float64) float64 {
+	if s.Tick == 0 {
+		return price
+	}
+	return math.Floor(price/s.Tick+1e-9) * s.Tick
+}
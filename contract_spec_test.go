@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractSpecPresets(t *testing.T) {
+	for _, n := range contractSpecPresetNames() {
+		s, err := NewContractSpecPreset(n)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Validate(), n)
+	}
+	_, err := NewContractSpecPreset("unknown")
+	assert.Error(t, err)
+
+	s := DefaultContractSpec()
+	s.LosscutRate = 0.2
+	assert.Error(t, s.Validate())
+	s = DefaultContractSpec()
+	s.MaxLeverage = 20
+	assert.Error(t, s.Validate())
+}
+
+func TestContractSpecRound(t *testing.T) {
+	s, _ := NewContractSpecPreset("stock-margin-jp")
+	assert.Equal(t, 0.0, s.RoundLot(99))
+	assert.Equal(t, 100.0, s.RoundLot(199))
+	assert.Equal(t, 300.0, s.RoundLot(300))
+	assert.Equal(t, 1001.0, s.ceilTick(1000.2))
+	assert.Equal(t, 1000.0, s.floorTick(1000.8))
+
+	s = &ContractSpec{LotMin: 0.1, LotStep: 0.1}
+	assert.InDelta(t, 0.3, s.RoundLot(0.3), 1e-9)
+	// 呼値の単位が0なら丸めない
+	assert.Equal(t, 1000.2, s.ceilTick(1000.2))
+}
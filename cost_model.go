@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 取引コストのモデル
+// 口座ごとに持ち、約定値や手数料を決める
+type CostModel interface {
+	// 仲値 mid のときの売値。評価額の計算にも使う
+	Bid(mid float64) float64
+	// 仲値 mid のときの買値
+	Ask(mid float64) float64
+	// 成行で買うときの約定値 (スリッページ込み)
+	BuyPrice(mid float64) float64
+	// 成行で売るときの約定値 (スリッページ込み)
+	SellPrice(mid float64) float64
+	// 約定値 price で1単位取引するときの手数料
+	Commission(price float64) float64
+	// ロスカット値 lv のポジションが、始値 open (仲値) の日にロスカットされるときの約定値
+	LosscutFill(side Side, lv, open float64) float64
+	// 現在のボラティリティ (VIXなど) を設定する
+	SetVolatility(iv float64)
+}
+
+// スプレッド、手数料、スリッページからなるコストモデル
+type BasicCostModel struct {
+	// スプレッド (売値と買値の差)。固定の値幅と、仲値に対する割合の合計
+	SpreadPoints float64
+	SpreadRate   float64
+	// ボラティリティが VolBase を超えると、超えた分 1 あたり VolSlope の割合だけスプレッドが広がる
+	VolBase  float64
+	VolSlope float64
+	// 1回の取引の手数料。固定の額と、約定代金に対する割合の合計
+	CommissionFixed float64
+	CommissionRate  float64
+	// 成行注文が不利な方向に滑る幅。固定の値幅と、仲値に対する割合の合計
+	SlippagePoints float64
+	SlippageRate   float64
+	// 窓を開けてロスカット値を割ったとき、始値の売値からさらに滑る幅。固定の値幅と、始値に対する割合の合計
+	GapSlippagePoints float64
+	GapSlippageRate   float64
+
+	iv float64
+}
+
+// 仲値からの片側のスプレッド
+func (m *BasicCostModel) halfSpread(mid float64) float64 {
+	s := m.SpreadPoints + mid*m.SpreadRate
+	if m.VolSlope != 0 {
+		s *= 1 + m.VolSlope*math.Max(0, m.iv-m.VolBase)
+	}
+	return s / 2
+}
+
+func (m *BasicCostModel) slippage(mid float64) float64 {
+	return m.SlippagePoints + mid*m.SlippageRate
+}
+
+func (m *BasicCostModel) Bid(mid float64) float64 {
+	// 割合のスプレッドだけのときは、丸め誤差が出ないように掛け算だけで計算する
+	if m.SpreadPoints == 0 && m.VolSlope == 0 {
+		return mid * (1 - m.SpreadRate/2)
+	}
+	return mid - m.halfSpread(mid)
+}
+
+func (m *BasicCostModel) Ask(mid float64) float64 {
+	if m.SpreadPoints == 0 && m.VolSlope == 0 {
+		return mid * (1 + m.SpreadRate/2)
+	}
+	return mid + m.halfSpread(mid)
+}
+
+func (m *BasicCostModel) BuyPrice(mid float64) float64 {
+	return m.Ask(mid) + m.slippage(mid)
+}
+
+func (m *BasicCostModel) SellPrice(mid float64) float64 {
+	return m.Bid(mid) - m.slippage(mid)
+}
+
+func (m *BasicCostModel) Commission(price float64) float64 {
+	return m.CommissionFixed + price*m.CommissionRate
+}
+
+// 買いなら、始値の売値がロスカット値より上ならロスカット値で約定する
+// そうでなければ始値の売値から GapSlippage だけ滑って約定する
+// 売りなら、始値の買値について上下を逆にする
+func (m *BasicCostModel) LosscutFill(side Side, lv, open float64) float64 {
+	gap := m.GapSlippagePoints + open*m.GapSlippageRate
+	if side == Short {
+		ask := m.Ask(open)
+		if ask < lv {
+			return lv
+		}
+		return ask + gap
+	}
+	bid := m.Bid(open)
+	if bid > lv {
+		return lv
+	}
+	return bid - gap
+}
+
+func (m *BasicCostModel) SetVolatility(iv float64) {
+	m.iv = iv
+}
+
+// コストモデルのプリセット
+var costModelPresets = map[string]func() *BasicCostModel{
+	// GMOクリック証券の株価指数CFD。おおよそ仲値の ±0.01% で約定する
+	"gmo-click": func() *BasicCostModel {
+		return &BasicCostModel{SpreadRate: 0.0002}
+	},
+	// GMOクリック証券の株価指数CFDで、VIXが20を超えるとスプレッドが広がるもの
+	"gmo-click-wide": func() *BasicCostModel {
+		return &BasicCostModel{SpreadRate: 0.0002, VolBase: 20, VolSlope: 0.1}
+	},
+	// コストなし
+	"none": func() *BasicCostModel {
+		return &BasicCostModel{}
+	},
+}
+
+// プリセットの名前 (名前順)
+func costModelPresetNames() []string {
+	ns := []string{}
+	for n := range costModelPresets {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// 名前からプリセットのコストモデルを作る
+func NewCostModelPreset(name string) (*BasicCostModel, error) {
+	f, ok := costModelPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker preset: %s (available: %v)", name, costModelPresetNames())
+	}
+	return f(), nil
+}
+
+// デフォルトのコストモデル (gmo-click)
+func DefaultCostModel() CostModel {
+	m, _ := NewCostModelPreset("gmo-click")
+	return m
+}
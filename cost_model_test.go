@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCostModel(t *testing.T) {
+	m := DefaultCostModel()
+	assert.Equal(t, 1000*0.9999, m.Bid(1000))
+	assert.Equal(t, 1000*1.0001, m.Ask(1000))
+	assert.Equal(t, m.Bid(1000), m.SellPrice(1000))
+	assert.Equal(t, m.Ask(1000), m.BuyPrice(1000))
+	assert.Equal(t, 0.0, m.Commission(1000))
+}
+
+func TestBasicCostModelSpread(t *testing.T) {
+	m := &BasicCostModel{SpreadPoints: 0.4, SpreadRate: 0.0002}
+	assert.InDelta(t, 999.7, m.Bid(1000), 1e-9)
+	assert.InDelta(t, 1000.3, m.Ask(1000), 1e-9)
+}
+
+func TestBasicCostModelVolatility(t *testing.T) {
+	m := &BasicCostModel{SpreadPoints: 1, VolBase: 20, VolSlope: 0.5}
+
+	m.SetVolatility(15)
+	assert.InDelta(t, 999.5, m.Bid(1000), 1e-9)
+	m.SetVolatility(24)
+	// スプレッドが3倍になる
+	assert.InDelta(t, 998.5, m.Bid(1000), 1e-9)
+	assert.InDelta(t, 1001.5, m.Ask(1000), 1e-9)
+}
+
+func TestBasicCostModelSlippageAndCommission(t *testing.T) {
+	m := &BasicCostModel{
+		SpreadPoints:    1,
+		SlippagePoints:  0.5,
+		SlippageRate:    0.001,
+		CommissionFixed: 2,
+		CommissionRate:  0.001,
+	}
+	assert.InDelta(t, 1002.0, m.BuyPrice(1000), 1e-9)
+	assert.InDelta(t, 998.0, m.SellPrice(1000), 1e-9)
+	assert.InDelta(t, 3.0, m.Commission(1000), 1e-9)
+}
+
+func TestNewCostModelPreset(t *testing.T) {
+	for _, n := range costModelPresetNames() {
+		_, err := NewCostModelPreset(n)
+		assert.NoError(t, err)
+	}
+	_, err := NewCostModelPreset("unknown")
+	assert.Error(t, err)
+}
+
+func TestBasicCostModelLosscutFill(t *testing.T) {
+	m := &BasicCostModel{SpreadPoints: 2, GapSlippageRate: 0.01}
+	assert.Equal(t, 900.0, m.LosscutFill(Long, 900, 950))
+	assert.Equal(t, 900.0, m.LosscutFill(Long, 900, 901.5))
+	// 始値の売値がロスカット値以下
+	assert.InDelta(t, 899.0-9.0, m.LosscutFill(Long, 900, 900), 1e-9)
+	assert.InDelta(t, 799.0-8.0, m.LosscutFill(Long, 900, 800), 1e-9)
+
+	// 売りは上下が逆
+	assert.Equal(t, 900.0, m.LosscutFill(Short, 900, 850))
+	assert.InDelta(t, 901.0+9.0, m.LosscutFill(Short, 900, 900), 1e-9)
+	assert.InDelta(t, 1001.0+10.0, m.LosscutFill(Short, 900, 1000), 1e-9)
+}
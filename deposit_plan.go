@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 入金計画
+// 毎月の入金は Day 日に行い、休場日なら次の営業日に入金する
+type DepositPlan struct {
+	// 計画の開始日。入金額の伸びの基準で、これより前の入金は行わない
+	// ゼロ値なら最初の営業日
+	Start time.Time
+	// 初回入金額
+	Initial float64
+	// 毎月の入金額
+	Monthly float64
+	// 毎月の入金日。月末より後の日なら月末
+	Day int
+	// 月ごとの追加入金 (ボーナスなど)。毎月の入金と同じ日に入金する
+	Bonus map[time.Month]float64
+	// 毎月の入金と追加入金の、年あたりの伸び率 (0.02 なら1年ごとに2%増える)
+	GrowthRate float64
+	// 指定した日の一時金
+	LumpSums []*LumpSum
+	// 追証が発生したら不足額を入金するかどうか
+	TopUp bool
+	// 追証1回あたりの入金の上限。0なら上限なし
+	MaxTopUp float64
+}
+
+type LumpSum struct {
+	Date   time.Time
+	Amount float64
+}
+
+// after (含まない) から until (含む) までに予定されている入金の合計
+func (p *DepositPlan) Due(after, until time.Time) float64 {
+	sum := 0.0
+	for m := Month.Start(after); !m.After(until); m = m.AddDate(0, 1, 0) {
+		d := p.scheduledDate(m)
+		if d.Before(p.Start) || !d.After(after) || d.After(until) {
+			continue
+		}
+		sum += (p.Monthly + p.Bonus[d.Month()]) * p.growth(d)
+	}
+	for _, l := range p.LumpSums {
+		if l.Date.Before(p.Start) || !l.Date.After(after) || l.Date.After(until) {
+			continue
+		}
+		sum += l.Amount
+	}
+	return sum
+}
+
+// 指定した月の入金予定日
+func (p *DepositPlan) scheduledDate(month time.Time) time.Time {
+	day := p.Day
+	if day < 1 {
+		day = 1
+	}
+	last := month.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	y, m, _ := month.Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, month.Location())
+}
+
+// 開始日から d までの伸び率
+// 経過した年数 (端数切り捨て) だけ複利で伸びる
+func (p *DepositPlan) growth(d time.Time) float64 {
+	if p.GrowthRate == 0 {
+		return 1
+	}
+	years := d.Year() - p.Start.Year()
+	if d.Before(p.Start.AddDate(years, 0, 0)) {
+		years--
+	}
+	return math.Pow(1+p.GrowthRate, float64(years))
+}
+
+// 追証の不足額 shortfall に対して入金する額
+func (p *DepositPlan) TopUpAmount(shortfall float64) float64 {
+	if !p.TopUp || shortfall <= 0 {
+		return 0
+	}
+	if p.MaxTopUp > 0 {
+		return math.Min(shortfall, p.MaxTopUp)
+	}
+	return shortfall
+}
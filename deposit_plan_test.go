@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepositPlanDueMonthly(t *testing.T) {
+	p := &DepositPlan{
+		Start:   date("2020-01-01"),
+		Monthly: 100,
+		Day:     25,
+	}
+
+	assert.Equal(t, 0.0, p.Due(date("2020-01-01"), date("2020-01-24")))
+	assert.Equal(t, 100.0, p.Due(date("2020-01-24"), date("2020-01-25")))
+	// 休場日だった場合は次の営業日に入金
+	assert.Equal(t, 100.0, p.Due(date("2020-01-24"), date("2020-01-27")))
+	assert.Equal(t, 0.0, p.Due(date("2020-01-25"), date("2020-01-27")))
+	// 月をまたいでも予定日の数だけ入金
+	assert.Equal(t, 300.0, p.Due(date("2020-01-01"), date("2020-03-31")))
+}
+
+func TestDepositPlanDueEndOfMonth(t *testing.T) {
+	p := &DepositPlan{
+		Start:   date("2020-01-01"),
+		Monthly: 100,
+		Day:     31,
+	}
+
+	assert.Equal(t, 100.0, p.Due(date("2020-02-28"), date("2020-02-29")))
+	assert.Equal(t, 0.0, p.Due(date("2020-04-01"), date("2020-04-29")))
+	assert.Equal(t, 100.0, p.Due(date("2020-04-29"), date("2020-04-30")))
+}
+
+func TestDepositPlanDueBeforeStart(t *testing.T) {
+	p := &DepositPlan{
+		Start:   date("2020-01-15"),
+		Monthly: 100,
+		Day:     1,
+	}
+
+	assert.Equal(t, 0.0, p.Due(date("2019-12-31"), date("2020-01-31")))
+	assert.Equal(t, 100.0, p.Due(date("2020-01-31"), date("2020-02-03")))
+}
+
+func TestDepositPlanDueBonusAndGrowth(t *testing.T) {
+	p := &DepositPlan{
+		Start:      date("2020-01-01"),
+		Monthly:    100,
+		Day:        10,
+		Bonus:      map[time.Month]float64{time.June: 500, time.December: 500},
+		GrowthRate: 0.1,
+	}
+
+	assert.Equal(t, 100.0, p.Due(date("2020-05-01"), date("2020-05-10")))
+	assert.Equal(t, 600.0, p.Due(date("2020-06-01"), date("2020-06-10")))
+	assert.InDelta(t, 110.0, p.Due(date("2021-01-01"), date("2021-01-10")), 1e-9)
+	assert.InDelta(t, 660.0, p.Due(date("2021-12-01"), date("2021-12-10")), 1e-9)
+	assert.InDelta(t, 121.0, p.Due(date("2022-01-01"), date("2022-01-10")), 1e-9)
+}
+
+func TestDepositPlanDueLumpSums(t *testing.T) {
+	p := &DepositPlan{
+		Start: date("2020-01-01"),
+		LumpSums: []*LumpSum{
+			{Date: date("2020-03-01"), Amount: 1000},
+			{Date: date("2020-03-02"), Amount: 2000},
+		},
+	}
+
+	// 2020-03-01 は日曜日なので次の営業日に入金
+	assert.Equal(t, 3000.0, p.Due(date("2020-02-28"), date("2020-03-02")))
+	assert.Equal(t, 0.0, p.Due(date("2020-03-02"), date("2020-03-03")))
+}
+
+func TestDepositPlanTopUpAmount(t *testing.T) {
+	p := &DepositPlan{}
+	assert.Equal(t, 0.0, p.TopUpAmount(100))
+
+	p.TopUp = true
+	assert.Equal(t, 100.0, p.TopUpAmount(100))
+	assert.Equal(t, 0.0, p.TopUpAmount(0))
+
+	p.MaxTopUp = 30
+	assert.Equal(t, 30.0, p.TopUpAmount(100))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// 配当相当額 (価格調整額) の予定
+// 権利落ち日と、指数1単位あたりの配当の額。権利落ち日の昇順に並ぶ
+type DividendSchedule struct {
+	dates   []time.Time
+	amounts []float64
+}
+
+// after (含まない) から until (含む) までに権利落ち日がある配当の合計
+// バックテストは毎日呼ぶので、予定全体は走査せずに二分探索する
+func (s *DividendSchedule) Due(after, until time.Time) float64 {
+	// after より後の最初の添字
+	i := sort.Search(len(s.dates), func(i int) bool {
+		return s.dates[i].After(after)
+	})
+	sum := 0.0
+	for ; i < len(s.dates) && !s.dates[i].After(until); i++ {
+		sum += s.amounts[i]
+	}
+	return sum
+}
+
+// 配当のCSVを読み込む
+// 1列目が権利落ち日で、column の列 (空なら2列目) を配当の額として使う
+func ReadDividendSchedule(path string, column string, layouts []string) (*DividendSchedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ParseDividendSchedule(f, column, layouts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+func ParseDividendSchedule(r io.Reader, column string, layouts []string) (*DividendSchedule, error) {
+	dates, amounts, err := parseDatedValues(r, column, layouts)
+	if err != nil {
+		return nil, err
+	}
+	return &DividendSchedule{dates: dates, amounts: amounts}, nil
+}
+
+// 価格指数とトータルリターン指数の終値から配当を推定する
+// 前日の価格指数をトータルリターン指数と同じだけ動かした値と、実際の価格指数との差を配当とみなす
+// 推定値が負になる日や、差が minRatio (価格に対する比) に満たない日は配当なしとする
+// 両方とも日付の昇順に並んでいること。片方にしかない日付は使わない
+func EstimateDividends(price, tr []*DailyData, minRatio float64) (*DividendSchedule, error) {
+	ps, ts, _, err := JoinDailyData(price, tr, JoinInner)
+	if err != nil {
+		return nil, err
+	}
+	s := &DividendSchedule{}
+	for i := 1; i < len(ps); i++ {
+		expected := ps[i-1].close * ts[i].close / ts[i-1].close
+		d := expected - ps[i].close
+		if d <= 0 || d < ps[i].close*minRatio || math.IsNaN(d) {
+			continue
+		}
+		s.dates = append(s.dates, ps[i].date)
+		s.amounts = append(s.amounts, d)
+	}
+	return s, nil
+}
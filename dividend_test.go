@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDividendScheduleDue(t *testing.T) {
+	csv := "Date,Dividend\n2020-03-20,1.5\n2020-06-19,1.6\n2020-06-22,0.1\n"
+	s, err := ParseDividendSchedule(strings.NewReader(csv), "", []string{dateLayout})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0.0, s.Due(date("2020-03-18"), date("2020-03-19")))
+	assert.Equal(t, 1.5, s.Due(date("2020-03-19"), date("2020-03-20")))
+	assert.Equal(t, 0.0, s.Due(date("2020-03-20"), date("2020-03-23")))
+	assert.InDelta(t, 1.7, s.Due(date("2020-06-18"), date("2020-06-22")), 1e-12)
+	assert.Equal(t, 0.0, s.Due(date("2020-06-22"), date("2020-12-31")))
+
+	// 二分探索するので、日付の順に並んでいなければエラー
+	csv = "Date,Dividend\n2020-06-19,1.6\n2020-03-20,1.5\n"
+	_, err = ParseDividendSchedule(strings.NewReader(csv), "", []string{dateLayout})
+	assert.Error(t, err)
+}
+
+func TestEstimateDividends(t *testing.T) {
+	price := []*DailyData{
+		{date: date("2020-01-02"), close: 100},
+		{date: date("2020-01-03"), close: 99},
+		{date: date("2020-01-06"), close: 101},
+	}
+	tr := []*DailyData{
+		{date: date("2020-01-02"), close: 200},
+		{date: date("2020-01-03"), close: 200},
+		{date: date("2020-01-06"), close: 204.0404},
+	}
+
+	s, err := EstimateDividends(price, tr, 0.0001)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(s.dates))
+	assert.Equal(t, date("2020-01-03"), s.dates[0])
+	assert.InDelta(t, 1.0, s.amounts[0], 1e-9)
+}
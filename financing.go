@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// 年率の金利
+type RateSource interface {
+	// 指定した日の年率 (0.01 なら1%)
+	Rate(t time.Time) float64
+}
+
+// 固定の年率
+type FixedRate float64
+
+func (r FixedRate) Rate(t time.Time) float64 {
+	return float64(r)
+}
+
+// 日付ごとの年率の系列
+// 次の日付までは直前の値が続くものとする。最初の日付より前は最初の値を使う
+type RateSeries struct {
+	dates []time.Time
+	rates []float64
+}
+
+func (s *RateSeries) Rate(t time.Time) float64 {
+	// t より後の最初の添字
+	i := sort.Search(len(s.dates), func(i int) bool {
+		return s.dates[i].After(t)
+	})
+	if i == 0 {
+		return s.rates[0]
+	}
+	return s.rates[i-1]
+}
+
+// 金利のCSVを読み込む
+// 1列目が日付で、column の列 (空なら2列目) を値として使う
+// FRED のデータのように値が "." や空の行は読み飛ばす
+// percent なら値を % 表記とみなして100で割る
+func ReadRateSeries(path string, column string, percent bool, layouts []string) (*RateSeries, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ParseRateSeries(f, column, percent, layouts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+func ParseRateSeries(r io.Reader, column string, percent bool, layouts []string) (*RateSeries, error) {
+	dates, rates, err := parseDatedValues(r, column, layouts)
+	if err != nil {
+		return nil, err
+	}
+	if len(dates) == 0 {
+		return nil, fmt.Errorf("no rates")
+	}
+	if percent {
+		for i := range rates {
+			rates[i] /= 100
+		}
+	}
+	return &RateSeries{dates: dates, rates: rates}, nil
+}
+
+// 金利調整額のモデル
+// 買いポジションは、建玉の時価に対して (金利 + 上乗せ) の年率で日割りの金利を支払う
+type FinancingModel struct {
+	Rate     RateSource
+	Markup   float64 // 業者の上乗せ分の年率
+	DayCount float64 // 1年の日数
+}
+
+// 時価 notional の買いポジションを date から days 日間保有したときの金利調整額
+// 受け取りは正、支払いは負
+func (f *FinancingModel) Charge(notional float64, date time.Time, days int) float64 {
+	return -notional * (f.Rate.Rate(date) + f.Markup) * float64(days) / f.DayCount
+}
+
+// 時価 notional の売りポジションを date から days 日間保有したときの金利調整額
+// 金利から上乗せ分を引いた分を受け取る。金利が低ければ支払いになる
+func (f *FinancingModel) ShortCharge(notional float64, date time.Time, days int) float64 {
+	return notional * (f.Rate.Rate(date) - f.Markup) * float64(days) / f.DayCount
+}
+
+// date の次の営業日まで保有したときに、何日分の金利がかかるか
+// next がゼロ値なら、次の平日を次の営業日とみなす
+// 週末や休場日の分は、その前の営業日にまとめてかかる
+func financingDays(date, next time.Time) int {
+	if next.IsZero() {
+		next = date.AddDate(0, 0, 1)
+		for next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return int(next.Sub(date).Hours()/24 + 0.5)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateSeries(t *testing.T) {
+	csv := "DATE,FEDFUNDS\n2020-01-01,1.55\n2020-02-01,.\n2020-03-01,0.65\n"
+	s, err := ParseRateSeries(strings.NewReader(csv), "fedfunds", true, []string{dateLayout})
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.0155, s.Rate(date("2019-12-01")), 1e-12)
+	assert.InDelta(t, 0.0155, s.Rate(date("2020-01-01")), 1e-12)
+	assert.InDelta(t, 0.0155, s.Rate(date("2020-02-15")), 1e-12)
+	assert.InDelta(t, 0.0065, s.Rate(date("2020-03-01")), 1e-12)
+	assert.InDelta(t, 0.0065, s.Rate(date("2021-01-01")), 1e-12)
+
+	_, err = ParseRateSeries(strings.NewReader(csv), "rate", true, []string{dateLayout})
+	assert.Error(t, err)
+}
+
+func TestFinancingModelCharge(t *testing.T) {
+	f := &FinancingModel{
+		Rate:     FixedRate(0.02),
+		Markup:   0.03,
+		DayCount: 365,
+	}
+	assert.InDelta(t, -1000*0.05/365, f.Charge(1000, date("2020-01-06"), 1), 1e-12)
+	assert.InDelta(t, -1000*0.05*3/365, f.Charge(1000, date("2020-01-10"), 3), 1e-12)
+}
+
+func TestFinancingDays(t *testing.T) {
+	// 2020-01-10 は金曜日
+	assert.Equal(t, 1, financingDays(date("2020-01-09"), date("2020-01-10")))
+	assert.Equal(t, 3, financingDays(date("2020-01-10"), date("2020-01-13")))
+	assert.Equal(t, 3, financingDays(date("2020-01-10"), time.Time{}))
+	assert.Equal(t, 1, financingDays(date("2020-01-09"), time.Time{}))
+	// 祝日の前
+	assert.Equal(t, 4, financingDays(date("2020-01-10"), date("2020-01-14")))
+}
+
+func TestFinancingModelShortCharge(t *testing.T) {
+	f := &FinancingModel{Rate: FixedRate(0.05), Markup: 0.02, DayCount: 365}
+	assert.InDelta(t, 1000*0.03*3/365, f.ShortCharge(1000, date("2020-01-03"), 3), 1e-9)
+
+	// 金利が上乗せ分より低ければ支払い
+	f.Rate = FixedRate(0.01)
+	assert.True(t, f.ShortCharge(1000, date("2020-01-03"), 1) < 0)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// 為替レートの系列
+// 口座通貨での、銘柄の通貨1単位の価格 (USD/JPY なら 1ドルあたりの円)
+// 次の日付までは直前の値が続くものとする。最初の日付より前は最初の値を使うが、
+// バックテストはデータの初めより後に始まる系列をエラーにする
+type FXRates struct {
+	series *RateSeries
+}
+
+// 指定した日の為替レート
+func (r *FXRates) Rate(t time.Time) float64 {
+	return r.series.Rate(t)
+}
+
+// 指定した日の寄り付きで分かっている為替レート (前日までの最後の値)
+// CSVの値は終値のことが多く、その日の値は寄り付きではまだ分からないため
+// 前日までの値がなければその日の値を使う
+func (r *FXRates) RateAtOpen(t time.Time) float64 {
+	return r.series.Rate(t.AddDate(0, 0, -1))
+}
+
+// 最初の日付
+func (r *FXRates) Start() time.Time {
+	return r.series.dates[0]
+}
+
+// 為替レートのCSVを読み込む
+// 1列目が日付で、column の列 (空なら2列目) を値として使う
+// Yahoo! Finance のヒストリカルデータなら column に "Close" を指定する
+// invert なら値の逆数を使う (EUR 口座で USD の銘柄を扱うのに EUR/USD のデータを使う場合など)
+func ReadFXRates(path string, column string, invert bool, layouts []string) (*FXRates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := ParseFXRates(f, column, invert, layouts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+func ParseFXRates(r io.Reader, column string, invert bool, layouts []string) (*FXRates, error) {
+	dates, rates, err := parseDatedValues(r, column, layouts)
+	if err != nil {
+		return nil, err
+	}
+	if len(dates) == 0 {
+		return nil, fmt.Errorf("no rates")
+	}
+	for i, v := range rates {
+		if v <= 0 {
+			return nil, fmt.Errorf("rate must be positive: %s, %v", formatDate(dates[i]), v)
+		}
+		if invert {
+			rates[i] = 1 / v
+		}
+	}
+	return &FXRates{series: &RateSeries{dates: dates, rates: rates}}, nil
+}
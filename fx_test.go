@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFXRates(t *testing.T) {
+	csv := "Date,Open,Close\n2020-01-02,108.5,108.7\n2020-01-03,null,null\n2020-01-06,108.1,108.4\n"
+	r, err := ParseFXRates(strings.NewReader(csv), "Close", false, []string{dateLayout})
+	assert.NoError(t, err)
+	assert.Equal(t, 108.7, r.Rate(date("2020-01-01")))
+	assert.Equal(t, 108.7, r.Rate(date("2020-01-03")))
+	assert.Equal(t, 108.4, r.Rate(date("2020-01-07")))
+	assert.Equal(t, date("2020-01-02"), r.Start())
+
+	// 寄り付きでは前日までの値を使う
+	assert.Equal(t, 108.7, r.RateAtOpen(date("2020-01-06")))
+	assert.Equal(t, 108.4, r.RateAtOpen(date("2020-01-07")))
+
+	r, err = ParseFXRates(strings.NewReader("Date,Rate\n2020-01-02,0.8\n"), "", true, []string{dateLayout})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.25, r.Rate(date("2020-01-02")))
+
+	_, err = ParseFXRates(strings.NewReader("Date,Rate\n2020-01-02,0\n"), "", false, []string{dateLayout})
+	assert.Error(t, err)
+}
+
+func TestBacktestFXRatesStart(t *testing.T) {
+	index, iv := syntheticData(10)
+	c := syntheticConfig(t)
+	c.Currency.Account = "JPY"
+	c.Currency.Index = "USD"
+	rates := func(csv string) *auxData {
+		r, err := ParseFXRates(strings.NewReader(csv), "", false, []string{dateLayout})
+		assert.NoError(t, err)
+		return &auxData{fx: map[string]*FXRates{"USD": r}}
+	}
+
+	_, err := newBacktestWithData(c, index, iv, rates("Date,Rate\n2019-12-31,100\n"))
+	assert.NoError(t, err)
+
+	// データの初めより後に始まる為替レートは使わない
+	_, err = newBacktestWithData(c, index, iv, rates("Date,Rate\n2020-01-03,100\n"))
+	assert.Error(t, err)
+}
@@ -2,63 +2,286 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type DailyData struct {
-	date  string
-	open  float64
-	close float64
-	high  float64
-	low   float64
+	date     time.Time
+	open     float64
+	close    float64
+	high     float64
+	low      float64
+	adjClose float64
+	volume   float64
+}
+
+func (d *DailyData) Date() time.Time   { return d.date }
+func (d *DailyData) Open() float64     { return d.open }
+func (d *DailyData) High() float64     { return d.high }
+func (d *DailyData) Low() float64      { return d.low }
+func (d *DailyData) Close() float64    { return d.close }
+func (d *DailyData) AdjClose() float64 { return d.adjClose }
+func (d *DailyData) Volume() float64   { return d.volume }
+
+// 値が "null" の行の扱い
+// Yahoo! Finance のデータには休場日などに null だけの行が含まれる
+type NullPolicy string
+
+const (
+	// 行を読み飛ばす
+	NullSkip NullPolicy = "skip"
+	// 直前の行の終値で埋める (出来高は0)。先頭の行は読み飛ばす
+	NullRepair NullPolicy = "repair"
+	// エラーにする
+	NullError NullPolicy = "error"
+)
+
+func ParseNullPolicy(s string) (NullPolicy, error) {
+	switch p := NullPolicy(s); p {
+	case NullSkip, NullRepair, NullError:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown null policy: %s", s)
+}
+
+// CSV読み込みの設定
+type CSVOptions struct {
+	Null NullPolicy
+	// 日付の書式 (time.Parse のレイアウト)。先頭から順に試す
+	Layouts []string
+}
+
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{
+		Null:    NullSkip,
+		Layouts: []string{dateLayout},
+	}
+}
+
+// 列名 (小文字) と、必須かどうか
+var dailyDataColumns = []struct {
+	name     string
+	required bool
+}{
+	{"date", true},
+	{"open", true},
+	{"high", true},
+	{"low", true},
+	{"close", true},
+	{"adj close", false},
+	{"volume", false},
 }
 
 func ReadDailyData(path string) ([]*DailyData, error) {
+	return ReadDailyDataWithOptions(path, DefaultCSVOptions())
+}
+
+func ReadDailyDataWithOptions(path string, opts CSVOptions) ([]*DailyData, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	r := csv.NewReader(f)
-
-	var line []string
-	acc := []*DailyData{}
-	_, err = r.Read()
+	ds, err := ParseDailyData(f, opts)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ds, nil
+}
+
+// Yahoo! Finance 形式のCSVを読み込む
+// 列はヘッダの名前で対応付けるので、順番は問わない
+// エラーには行番号 (ヘッダが1行目) を含める
+func ParseDailyData(r io.Reader, opts CSVOptions) ([]*DailyData, error) {
+	if _, err := ParseNullPolicy(string(opts.Null)); err != nil {
 		return nil, err
 	}
+	if len(opts.Layouts) == 0 {
+		opts.Layouts = DefaultCSVOptions().Layouts
+	}
+
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("line 1: failed to read header: %v", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		// BOM付きのファイルもある
+		h = strings.TrimPrefix(h, "\ufeff")
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range dailyDataColumns {
+		if _, ok := cols[c.name]; c.required && !ok {
+			return nil, fmt.Errorf("line 1: missing column %q", c.name)
+		}
+	}
 
+	acc := []*DailyData{}
+	lineNum := 1
 	for {
-		line, err = r.Read()
-		if err != nil {
+		line, err := cr.Read()
+		if err == io.EOF {
 			break
 		}
-		open, err := strconv.ParseFloat(line[1], 64)
+		lineNum++
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
 		}
-		high, err := strconv.ParseFloat(line[2], 64)
+
+		d, isNull, err := parseDailyDataLine(line, cols, opts.Layouts)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
 		}
-		low, err := strconv.ParseFloat(line[3], 64)
-		if err != nil {
-			return nil, err
+		if isNull {
+			switch opts.Null {
+			case NullError:
+				return nil, fmt.Errorf("line %d: null value on %s", lineNum, formatDate(d.date))
+			case NullRepair:
+				if len(acc) != 0 {
+					prev := acc[len(acc)-1].close
+					d.open, d.high, d.low, d.close, d.adjClose, d.volume = prev, prev, prev, prev, prev, 0
+					acc = append(acc, d)
+					log.Printf("line %d: repaired null row on %s", lineNum, formatDate(d.date))
+					continue
+				}
+			}
+			log.Printf("line %d: skipped null row on %s", lineNum, formatDate(d.date))
+			continue
+		}
+		acc = append(acc, d)
+	}
+
+	return acc, nil
+}
+
+// 1行を読み込む
+// 価格のいずれかが null なら isNull を true にして返す
+func parseDailyDataLine(line []string, cols map[string]int, layouts []string) (d *DailyData, isNull bool, err error) {
+	field := func(name string) (string, bool) {
+		i, ok := cols[name]
+		if !ok {
+			return "", false
+		}
+		return strings.TrimSpace(line[i]), true
+	}
+	parse := func(name string) (float64, error) {
+		s, _ := field(name)
+		if s == "null" {
+			isNull = true
+			return 0, nil
 		}
-		close, err := strconv.ParseFloat(line[4], 64)
+		v, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return nil, err
+			return 0, fmt.Errorf("invalid %s: %q", name, s)
+		}
+		return v, nil
+	}
+
+	s, _ := field("date")
+	date, err := parseDate(s, layouts)
+	if err != nil {
+		return nil, false, err
+	}
+	d = &DailyData{date: date}
+	if d.open, err = parse("open"); err != nil {
+		return nil, false, err
+	}
+	if d.high, err = parse("high"); err != nil {
+		return nil, false, err
+	}
+	if d.low, err = parse("low"); err != nil {
+		return nil, false, err
+	}
+	if d.close, err = parse("close"); err != nil {
+		return nil, false, err
+	}
+	d.adjClose = d.close
+	if _, ok := field("adj close"); ok {
+		if d.adjClose, err = parse("adj close"); err != nil {
+			return nil, false, err
 		}
-		d := &DailyData{
-			date:  line[0],
-			open:  open,
-			close: close,
-			high:  high,
-			low:   low,
+	}
+	if s, ok := field("volume"); ok && s != "null" {
+		if d.volume, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, false, fmt.Errorf("invalid volume: %q", s)
+		}
+	}
+	return d, isNull, nil
+}
+
+// 指定した書式のいずれかで日付を読み込む
+func parseDate(s string, layouts []string) (time.Time, error) {
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
 		}
-		acc = append(acc, d)
 	}
+	return time.Time{}, fmt.Errorf("invalid date: %q", s)
+}
 
-	return acc, nil
+// 日付と値の2列からなるCSVを読み込む
+// 1列目が日付で、column の列 (空なら2列目) を値として使う
+// FRED のデータのように値が "." や空の行は読み飛ばす
+func parseDatedValues(r io.Reader, column string, layouts []string) ([]time.Time, []float64, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("line 1: failed to read header: %v", err)
+	}
+	col := 1
+	if column != "" {
+		col = -1
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), column) {
+				col = i
+			}
+		}
+		if col < 0 {
+			return nil, nil, fmt.Errorf("line 1: missing column %q", column)
+		}
+	}
+	if col >= len(header) {
+		return nil, nil, fmt.Errorf("line 1: value column not found")
+	}
+
+	dates := []time.Time{}
+	values := []float64{}
+	lineNum := 1
+	for {
+		line, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		lineNum++
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		d, err := parseDate(strings.TrimSpace(line[0]), layouts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		s := strings.TrimSpace(line[col])
+		if s == "." || s == "" || s == "null" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid value: %q", lineNum, s)
+		}
+		if len(dates) != 0 && !dates[len(dates)-1].Before(d) {
+			return nil, nil, fmt.Errorf("line %d: dates are not sorted", lineNum)
+		}
+		dates = append(dates, d)
+		values = append(values, v)
+	}
+	return dates, values, nil
 }
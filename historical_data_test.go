@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yahooCSV = `Date,Open,High,Low,Close,Adj Close,Volume
+2020-01-02,100,110,90,105,104,1000
+2020-01-03,null,null,null,null,null,null
+2020-01-06,105,115,95,110,109,2000
+`
+
+func TestParseDailyData(t *testing.T) {
+	ds, err := ParseDailyData(strings.NewReader(yahooCSV), CSVOptions{Null: NullSkip})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ds))
+	assert.Equal(t, &DailyData{
+		date:     date("2020-01-02"),
+		open:     100,
+		high:     110,
+		low:      90,
+		close:    105,
+		adjClose: 104,
+		volume:   1000,
+	}, ds[0])
+	assert.Equal(t, date("2020-01-06"), ds[1].date)
+}
+
+func TestParseDailyDataColumnOrder(t *testing.T) {
+	// 列の順番が違っても、Adj Close や Volume がなくても読める
+	csv := "Close,Date,Low,High,Open\n105,2020-01-02,90,110,100\n"
+	ds, err := ParseDailyData(strings.NewReader(csv), DefaultCSVOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ds))
+	assert.Equal(t, 100.0, ds[0].open)
+	assert.Equal(t, 110.0, ds[0].high)
+	assert.Equal(t, 90.0, ds[0].low)
+	assert.Equal(t, 105.0, ds[0].close)
+	assert.Equal(t, 105.0, ds[0].adjClose)
+	assert.Equal(t, 0.0, ds[0].volume)
+}
+
+func TestParseDailyDataNullRepair(t *testing.T) {
+	ds, err := ParseDailyData(strings.NewReader(yahooCSV), CSVOptions{Null: NullRepair})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(ds))
+	assert.Equal(t, date("2020-01-03"), ds[1].date)
+	assert.Equal(t, 105.0, ds[1].open)
+	assert.Equal(t, 105.0, ds[1].low)
+	assert.Equal(t, 105.0, ds[1].close)
+	assert.Equal(t, 0.0, ds[1].volume)
+}
+
+func TestParseDailyDataNullError(t *testing.T) {
+	_, err := ParseDailyData(strings.NewReader(yahooCSV), CSVOptions{Null: NullError})
+	assert.EqualError(t, err, "line 3: null value on 2020-01-03")
+}
+
+func TestParseDailyDataMalformed(t *testing.T) {
+	{
+		csv := "Date,Open,High,Low,Close\n2020-01-02,100,110,90,105\n2020-01-03,abc,110,90,105\n"
+		_, err := ParseDailyData(strings.NewReader(csv), DefaultCSVOptions())
+		assert.EqualError(t, err, `line 3: invalid open: "abc"`)
+	}
+	{
+		// 列数が足りない
+		csv := "Date,Open,High,Low,Close\n2020-01-02,100,110\n"
+		_, err := ParseDailyData(strings.NewReader(csv), DefaultCSVOptions())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2:")
+	}
+	{
+		csv := "Date,Open,High,Close\n2020-01-02,100,110,105\n"
+		_, err := ParseDailyData(strings.NewReader(csv), DefaultCSVOptions())
+		assert.EqualError(t, err, `line 1: missing column "low"`)
+	}
+}
+
+func TestParseDailyDataLayouts(t *testing.T) {
+	csv := "Date,Open,High,Low,Close\n01/02/2020,100,110,90,105\n2020-01-03,100,110,90,105\n"
+	ds, err := ParseDailyData(strings.NewReader(csv), CSVOptions{
+		Null:    NullSkip,
+		Layouts: []string{"01/02/2006", dateLayout},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, date("2020-01-02"), ds[0].date)
+	assert.Equal(t, date("2020-01-03"), ds[1].date)
+
+	_, err = ParseDailyData(strings.NewReader(csv), DefaultCSVOptions())
+	assert.EqualError(t, err, `line 2: invalid date: "01/02/2020"`)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// 銘柄
+// 価格の系列ごとに、取引コストと取引の決まりを持つ
+type Instrument struct {
+	Name     string
+	Currency string // 価格の通貨。空なら口座の通貨と同じ
+	Cost     CostModel
+	Spec     *ContractSpec
+}
+
+// 株価指数CFDの銘柄
+func NewIndexInstrument(name string, c CostModel) *Instrument {
+	return &Instrument{
+		Name: name,
+		Cost: c,
+		Spec: DefaultContractSpec(),
+	}
+}
+
+// ひとつの銘柄の建玉
+// 現金と証拠金は口座全体で共有する
+type Holding struct {
+	account    *Account
+	instrument *Instrument
+	cost       CostModel
+	longs      *Positions // 買いポジション
+	shorts     *Positions // 売りポジション
+	book       BookMode
+	fx         float64   // 口座通貨での、この銘柄の通貨1単位の価格
+	boundFX    float64   // 拘束中の証拠金の口座通貨での額。通貨が口座と違うときだけ使う
+	order      OrderSize // Open, OpenShort の注文の大きさ
+	mark       float64   // 口座全体の評価に使う仲値。取引やロスカットの判定で更新する。0ならまだ価格がない
+}
+
+func newHolding(a *Account, in *Instrument) *Holding {
+	return &Holding{
+		account:    a,
+		instrument: in,
+		cost:       in.Cost,
+		longs:      NewPositions(),
+		shorts:     NewPositions(),
+		book:       BookHedged,
+		order:      OrderSize{Quantity: 1},
+		fx:         1,
+	}
+}
+
+// 銘柄を返す
+func (h *Holding) Instrument() *Instrument {
+	return h.instrument
+}
+
+// 買いポジションの列を返す
+func (h *Holding) Positions() *Positions {
+	return h.longs
+}
+
+// 売りポジションの列を返す
+func (h *Holding) Shorts() *Positions {
+	return h.shorts
+}
+
+// 買いと売りを合わせたポジションの数
+func (h *Holding) PositionCount() int {
+	return h.longs.Size() + h.shorts.Size()
+}
+
+// 評価に使う仲値を返す
+func (h *Holding) Mark() float64 {
+	return h.mark
+}
+
+// 評価に使う仲値を設定する
+func (h *Holding) SetMark(price float64) {
+	h.mark = price
+}
+
+// 銘柄の通貨が口座の通貨と違うかどうか
+func (h *Holding) foreign() bool {
+	c := h.instrument.Currency
+	return c != "" && h.account.currency != "" && c != h.account.currency
+}
+
+// 為替レート (口座通貨での、銘柄の通貨1単位の価格) を返す
+func (h *Holding) FXRate() float64 {
+	return h.fx
+}
+
+// 為替レートを設定する
+// 通貨が口座と同じなら使わない
+func (h *Holding) SetFXRate(r float64) {
+	h.fx = r
+}
+
+// 銘柄の通貨での額を、現在の為替レートで口座通貨にする
+func (h *Holding) toAccount(x float64) float64 {
+	if !h.foreign() {
+		return x
+	}
+	return x * h.fx
+}
+
+// 口座通貨での額を、現在の為替レートで銘柄の通貨にする
+func (h *Holding) toQuote(x float64) float64 {
+	if !h.foreign() {
+		return x
+	}
+	return x / h.fx
+}
+
+// 買いと売りを合わせた必要証拠金 (口座通貨)
+// 現在の為替レートで換算する
+func (h *Holding) RequiredMargin() float64 {
+	return h.toAccount(h.longs.RequiredMargin() + h.shorts.RequiredMargin())
+}
+
+// 仲値 current での評価損益 (口座通貨)
+// 買いは売値、売りは買値で評価する
+func (h *Holding) profit(current float64) float64 {
+	return h.toAccount(h.longs.Profit(h.cost.Bid(current)) + h.shorts.Profit(h.cost.Ask(current)))
+}
+
+// 仲値 current での評価額 (口座通貨)
+// 拘束中の証拠金は拘束したときの為替レートで、評価損益は現在の為替レートで換算する
+func (h *Holding) valuation(current float64) float64 {
+	if !h.foreign() {
+		return h.longs.Valuation(h.cost.Bid(current)) + h.shorts.Valuation(h.cost.Ask(current))
+	}
+	return h.boundFX + h.profit(current)
+}
+
+// 拘束中の証拠金の合計 (銘柄の通貨)
+func (h *Holding) boundMargin() float64 {
+	return h.longs.BoundMargin() + h.shorts.BoundMargin()
+}
+
+// ポジションの証拠金が m (銘柄の通貨) だけ増えた分を未拘束残高から拘束する
+// m が負なら、その分を拘束中の証拠金から未拘束残高に戻す
+// 証拠金を変えた後に呼ぶこと
+// 口座通貨での証拠金は拘束したときの為替レートで決まり、戻すときは拘束中の額から按分する
+func (h *Holding) bindMargin(m float64) {
+	a := h.account
+	if !h.foreign() {
+		a.unboundCash -= m
+		return
+	}
+	c := m * h.fx
+	if m < 0 {
+		// 変えた後の合計と戻した分で按分する
+		if total := h.boundMargin() - m; total > 0 {
+			c = h.boundFX * m / total
+		}
+	}
+	h.boundFX += c
+	a.unboundCash -= c
+}
+
+// 決済したポジションの証拠金と損益を未拘束残高に戻す
+// ポジションは取り除いた後であること
+func (h *Holding) settle(p *Position, price float64) {
+	if !h.foreign() {
+		h.account.unboundCash += p.Valuation(price)
+		return
+	}
+	h.bindMargin(-p.BoundMargin())
+	h.account.unboundCash += h.toAccount(p.profit(price))
+}
+
+// 銘柄の決まりに従ったポジション
+func (h *Holding) newPosition(side Side, unit float64, quantity float64) *Position {
+	return NewPositionWithQuantity(side, unit, quantity, h.instrument.Spec)
+}
+
+// 成行で買うときの約定値。呼値の単位に切り上げる
+// 余力の確認と約定で同じ値を使うため、買う価格はすべてここから求める
+func (h *Holding) buyPrice(current float64) float64 {
+	return h.instrument.Spec.ceilTick(h.cost.BuyPrice(current))
+}
+
+// 成行で売るときの約定値。呼値の単位に切り捨てる
+func (h *Holding) sellPrice(current float64) float64 {
+	return h.instrument.Spec.floorTick(h.cost.SellPrice(current))
+}
+
+// 成行で数量 q だけ買うときの約定値
+// 手数料を支払い、コストを記録する
+func (h *Holding) buy(current float64, q float64) float64 {
+	a := h.account
+	h.mark = current
+	m := h.instrument.Spec.Multiplier * q
+	price := h.buyPrice(current)
+	ask := h.cost.Ask(current)
+	h.payCommission(price, q)
+	a.tradeCosts.Spread += h.toAccount((ask - current) * m)
+	a.tradeCosts.Slippage += h.toAccount((price - ask) * m)
+	h.fill(Long, q, price)
+	return price
+}
+
+// 成行で数量 q だけ売るときの約定値
+// 手数料を支払い、コストを記録する
+func (h *Holding) sell(current float64, q float64) float64 {
+	a := h.account
+	h.mark = current
+	m := h.instrument.Spec.Multiplier * q
+	price := h.sellPrice(current)
+	bid := h.cost.Bid(current)
+	h.payCommission(price, q)
+	a.tradeCosts.Spread += h.toAccount((current - bid) * m)
+	a.tradeCosts.Slippage += h.toAccount((bid - price) * m)
+	h.fill(Short, q, price)
+	return price
+}
+
+// 約定を記録する
+func (h *Holding) fill(side Side, q float64, price float64) {
+	a := h.account
+	a.fills = append(a.fills, &FillEvent{Date: a.date, Instrument: h.instrument.Name, Side: side, Quantity: q, Price: price})
+}
+
+func (h *Holding) payCommission(price float64, q float64) {
+	c := h.commission(price, q)
+	h.account.unboundCash -= c
+	h.account.tradeCosts.Commission += c
+}
+
+// 価格 price で数量 q だけ取引するときの手数料 (口座通貨)
+func (h *Holding) commission(price float64, q float64) float64 {
+	return h.toAccount(h.cost.Commission(price * h.instrument.Spec.Multiplier * q))
+}
+
+// 1回の注文の大きさ
+// Quantity と Amount のどちらかを指定する
+type OrderSize struct {
+	Quantity float64 // 数量
+	Amount   float64 // 約定代金。数量は取引単位に切り捨てる
+}
+
+// Open, OpenShort で使う注文の大きさを設定する
+func (h *Holding) SetOrderSize(o OrderSize) {
+	h.order = o
+}
+
+// 注文の大きさを、価格 price での数量にする
+func (h *Holding) orderQuantity(price float64) float64 {
+	if h.order.Amount != 0 {
+		return h.instrument.Spec.RoundLot(h.order.Amount / (price * h.instrument.Spec.Multiplier))
+	}
+	return h.instrument.Spec.RoundLot(h.order.Quantity)
+}
+
+// 余力があれば、現在値で買いポジションをひとつ建てる
+// 数量は注文の大きさで決まり、ポジションのロスカット値は lv に指定する
+// net なら、売りポジションがあれば代わりにそれをひとつ決済する
+func (h *Holding) Open(current float64, lv float64) {
+	h.OpenQuantity(current, lv, h.orderQuantity(h.buyPrice(current)))
+}
+
+// 余力があれば、現在値で数量 q の買いポジションをひとつ建てる
+// 数量は取引単位に切り捨て、建てた数量を返す
+// net なら、売りポジションがあれば代わりにそれをひとつ決済する
+func (h *Holding) OpenQuantity(current float64, lv float64, q float64) float64 {
+	if h.book == BookNet && h.shorts.Size() != 0 {
+		h.CloseShortMin(current)
+		return 0
+	}
+	q = h.instrument.Spec.RoundLot(q)
+	if q == 0 || !h.canOpenQuantity(Long, current, lv, q) {
+		return 0
+	}
+	p := h.newPosition(Long, h.buy(current, q), q)
+	p.SetLosscutValue(lv)
+	h.longs.Add(p)
+	h.bind(p)
+	return q
+}
+
+// ポジションを建てる余力があるかどうか確認
+func (h *Holding) CanOpen(current float64, lv float64) bool {
+	if h.book == BookNet && h.shorts.Size() != 0 {
+		return true
+	}
+	q := h.orderQuantity(h.buyPrice(current))
+	return q != 0 && h.canOpenQuantity(Long, current, lv, q)
+}
+
+// 余力があれば、現在値で売りポジションをひとつ建てる
+// 数量は注文の大きさで決まり、ポジションのロスカット値は lv に指定する
+// net なら、買いポジションがあれば代わりにそれをひとつ決済する
+func (h *Holding) OpenShort(current float64, lv float64) {
+	h.OpenShortQuantity(current, lv, h.orderQuantity(h.sellPrice(current)))
+}
+
+// 余力があれば、現在値で数量 q の売りポジションをひとつ建てる
+// 数量は取引単位に切り捨て、建てた数量を返す
+// net なら、買いポジションがあれば代わりにそれをひとつ決済する
+func (h *Holding) OpenShortQuantity(current float64, lv float64, q float64) float64 {
+	if h.book == BookNet && h.longs.Size() != 0 {
+		h.CloseMax(current)
+		return 0
+	}
+	q = h.instrument.Spec.RoundLot(q)
+	if q == 0 || !h.canOpenQuantity(Short, current, lv, q) {
+		return 0
+	}
+	p := h.newPosition(Short, h.sell(current, q), q)
+	p.SetLosscutValue(lv)
+	h.shorts.Add(p)
+	h.bind(p)
+	return q
+}
+
+// 売りポジションを建てる余力があるかどうか確認
+func (h *Holding) CanOpenShort(current float64, lv float64) bool {
+	if h.book == BookNet && h.longs.Size() != 0 {
+		return true
+	}
+	q := h.orderQuantity(h.sellPrice(current))
+	return q != 0 && h.canOpenQuantity(Short, current, lv, q)
+}
+
+// 数量 q のポジションを建てる余力があるかどうか確認
+func (h *Holding) canOpenQuantity(side Side, current float64, lv float64, q float64) bool {
+	return h.remaining(current) >= h.openCost(side, current, lv, q)
+}
+
+// 数量 q のポジションを建てるのに必要な額 (拘束証拠金と手数料、口座通貨)
+func (h *Holding) openCost(side Side, current float64, lv float64, q float64) float64 {
+	price := h.buyPrice(current)
+	if side == Short {
+		price = h.sellPrice(current)
+	}
+	p := h.newPosition(side, price, q)
+	p.SetLosscutValue(lv)
+	return h.toAccount(p.BoundMargin()) + h.commission(p.Unit(), q)
+}
+
+// 余力で建てられる最大の数量。取引単位に切り捨てる
+func (h *Holding) MaxOpenQuantity(side Side, current float64, lv float64) float64 {
+	return h.affordable(h.remaining(current), func(q float64) float64 {
+		return h.openCost(side, current, lv, q)
+	})
+}
+
+// cost(q) が r 以下になる最大の数量。取引単位に切り捨てる
+// cost は数量の1次式とみなして見積もり、誤差は取引単位ずつ減らして直す
+func (h *Holding) affordable(r float64, cost func(q float64) float64) float64 {
+	spec := h.instrument.Spec
+	// 数量0のポジションは作れないので、最小の取引数量とその2倍から見積もる
+	c1 := cost(spec.LotMin)
+	per := cost(spec.LotMin*2) - c1
+	if per <= 0 {
+		return 0
+	}
+	q := spec.RoundLot(spec.LotMin + (r-c1)/per*spec.LotMin)
+	for q > 0 && cost(q) > r {
+		q = spec.RoundLot(q - spec.LotStep)
+	}
+	return q
+}
+
+// 建てたポジションの証拠金を拘束する
+func (h *Holding) bind(p *Position) {
+	h.bindMargin(p.BoundMargin())
+	if h.account.unboundCash < 0 {
+		panic("unbound cash < 0")
+	}
+}
+
+// この銘柄を現在値 current で評価したときの口座の余力
+func (h *Holding) remaining(current float64) float64 {
+	return h.account.remainingWith(h, current)
+}
+
+// 建単価が最大の買いポジションを決済
+func (h *Holding) CloseMax(current float64) {
+	p := h.longs.Max()
+	if p != nil {
+		price := h.sell(current, p.Quantity())
+		h.longs.RemoveMax()
+		h.settle(p, price)
+	}
+}
+
+// 建単価が最小の買いポジションを決済
+func (h *Holding) CloseMin(current float64) {
+	p := h.longs.Min()
+	if p != nil {
+		price := h.sell(current, p.Quantity())
+		h.longs.RemoveMin()
+		h.settle(p, price)
+	}
+}
+
+// 建単価が最小の売りポジションを決済
+// 売りポジションの中で最も評価損が大きい
+func (h *Holding) CloseShortMin(current float64) {
+	p := h.shorts.Min()
+	if p != nil {
+		price := h.buy(current, p.Quantity())
+		h.shorts.RemoveMin()
+		h.settle(p, price)
+	}
+}
+
+// 建単価が最大の売りポジションを決済
+func (h *Holding) CloseShortMax(current float64) {
+	p := h.shorts.Max()
+	if p != nil {
+		price := h.buy(current, p.Quantity())
+		h.shorts.RemoveMax()
+		h.settle(p, price)
+	}
+}
+
+// 評価損が最も大きいポジションを決済
+// 建単価が最大の買いポジション、なければ建単価が最小の売りポジション
+func (h *Holding) closeWorst(current float64) {
+	if h.longs.Size() != 0 {
+		h.CloseMax(current)
+		return
+	}
+	h.CloseShortMin(current)
+}
+
+// 持っている建玉をすべて決済する
+func (h *Holding) CloseAll(current float64) {
+	for h.PositionCount() != 0 {
+		h.closeWorst(current)
+	}
+}
+
+// 価格 current の売値以上のロスカット値の買いポジションと、
+// 買値以下のロスカット値の売りポジションをロスカット
+// 通常はそのポジションに設定されているロスカット値で決済する
+// 始値 open の時点でロスカット値を越えていた (窓を開けた) 場合は、始値から決済する
+// 約定値はコストモデルが決める
+func (h *Holding) ExecLosscut(open float64, current float64) {
+	h.mark = current
+	// 買いはロスカット値の大きい順に、触れたものをすべてロスカットする
+	for p := h.longs.MaxLosscut(); p != nil && p.LosscutValue() >= h.cost.Bid(current); p = h.longs.MaxLosscut() {
+		h.losscut(h.longs, p, open)
+	}
+	// 売りはロスカット値の小さい順
+	for p := h.shorts.MinLosscut(); p != nil && p.LosscutValue() <= h.cost.Ask(current); p = h.shorts.MinLosscut() {
+		h.losscut(h.shorts, p, open)
+	}
+}
+
+func (h *Holding) losscut(ps *Positions, p *Position, open float64) {
+	a := h.account
+	lv := p.LosscutValue()
+	fill := h.cost.LosscutFill(p.Side(), lv, open)
+	ps.Remove(p)
+	h.settle(p, fill)
+	h.payCommission(fill, p.Quantity())
+	e := &LosscutEvent{
+		Date:         a.date,
+		Instrument:   h.instrument.Name,
+		Side:         p.Side(),
+		Unit:         p.Unit(),
+		LosscutValue: lv,
+		Fill:         fill,
+	}
+	e.Gap = e.Slippage() > 0
+	a.losscuts = append(a.losscuts, e)
+	log.Printf("Losscut!: instrument=%s, losscut_value=%f, fill=%f, position=%v", h.instrument.Name, lv, fill, p)
+}
+
+// 追証のときの決済の順番
+type LiquidationPolicy string
+
+const (
+	// 全建玉を決済する
+	LiquidateAll LiquidationPolicy = "all"
+	// 評価損が最も大きい建玉から、不足が解消するまで決済する
+	LiquidateWorstFirst LiquidationPolicy = "worst-first"
+	// 必要証拠金が最も大きい建玉から、不足が解消するまで決済する
+	LiquidateLargestMargin LiquidationPolicy = "largest-margin"
+)
+
+func ParseLiquidationPolicy(s string) (LiquidationPolicy, error) {
+	switch p := LiquidationPolicy(s); p {
+	case LiquidateAll, LiquidateWorstFirst, LiquidateLargestMargin:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown liquidation policy: %s", s)
+}
+
+// 決済の候補
+type liquidationCandidate struct {
+	holding  *Holding
+	position *Position
+	loss     float64 // 評価損 (口座通貨)
+	margin   float64 // 必要証拠金 (口座通貨)
+}
+
+// 各銘柄のすべての建玉を候補にする
+// 数量が建玉ごとに違うので、建単価の順では評価損や必要証拠金の大きさは決まらない
+// 価格は prices (銘柄ごとの仲値) で評価する
+func liquidationCandidates(hs []*Holding, prices map[*Holding]float64) []*liquidationCandidate {
+	acc := []*liquidationCandidate{}
+	for _, h := range hs {
+		current := prices[h]
+		h.longs.each(func(p *Position) {
+			acc = append(acc, &liquidationCandidate{h, p, h.toAccount(p.ValuationLoss(h.cost.Bid(current))), h.toAccount(p.RequiredMargin())})
+		})
+		h.shorts.each(func(p *Position) {
+			acc = append(acc, &liquidationCandidate{h, p, h.toAccount(p.ValuationLoss(h.cost.Ask(current))), h.toAccount(p.RequiredMargin())})
+		})
+	}
+	return acc
+}
+
+// 方針に従って決済するものを選ぶ。なければ nil
+func (p LiquidationPolicy) choose(cs []*liquidationCandidate) *liquidationCandidate {
+	var best *liquidationCandidate
+	for _, c := range cs {
+		if best == nil {
+			best = c
+			continue
+		}
+		switch p {
+		case LiquidateLargestMargin:
+			if c.margin > best.margin {
+				best = c
+			}
+		default:
+			if c.loss > best.loss {
+				best = c
+			}
+		}
+	}
+	return best
+}
+
+// 選んだ候補を決済する
+func (c *liquidationCandidate) close(current float64) {
+	h := c.holding
+	p := c.position
+	if p.Side() == Long {
+		price := h.sell(current, p.Quantity())
+		h.longs.Remove(p)
+		h.settle(p, price)
+		return
+	}
+	price := h.buy(current, p.Quantity())
+	h.shorts.Remove(p)
+	h.settle(p, price)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// 株価指数とIVの日付の揃え方
+type JoinPolicy string
+
+const (
+	// 両方にある日付だけを使う
+	JoinInner JoinPolicy = "inner"
+	// 株価指数の日付をすべて使い、IVがない日は直前のIVで埋める
+	JoinForwardFillIV JoinPolicy = "ffill-iv"
+	// 両方にある日付だけを使い、捨てた日付をログに出す
+	JoinDropReport JoinPolicy = "drop-report"
+)
+
+func ParseJoinPolicy(s string) (JoinPolicy, error) {
+	switch p := JoinPolicy(s); p {
+	case JoinInner, JoinForwardFillIV, JoinDropReport:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown join policy: %s", s)
+}
+
+// 日付を揃えたときの診断結果
+type JoinReport struct {
+	Policy    JoinPolicy `json:"policy" yaml:"policy"`
+	Joined    int        `json:"joined" yaml:"joined"`         // 揃えた後の日数
+	IndexOnly []string   `json:"index_only" yaml:"index_only"` // 株価指数にしかない日付
+	IVOnly    []string   `json:"iv_only" yaml:"iv_only"`       // IVにしかない日付
+	Filled    []string   `json:"filled" yaml:"filled"`         // 直前のIVで埋めた日付
+	Dropped   []string   `json:"dropped" yaml:"dropped"`       // 捨てた日付
+}
+
+// 不一致があったかどうか
+func (r *JoinReport) HasMismatch() bool {
+	return len(r.IndexOnly) != 0 || len(r.IVOnly) != 0
+}
+
+// 株価指数とIVを日付で揃える
+// どちらも日付の昇順に並んでいること
+// 返す2つの列は同じ長さで、同じ添字が同じ日付になる
+func JoinDailyData(index, iv []*DailyData, policy JoinPolicy) ([]*DailyData, []*DailyData, *JoinReport, error) {
+	if _, err := ParseJoinPolicy(string(policy)); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkSorted(index); err != nil {
+		return nil, nil, nil, fmt.Errorf("index: %v", err)
+	}
+	if err := checkSorted(iv); err != nil {
+		return nil, nil, nil, fmt.Errorf("iv: %v", err)
+	}
+
+	r := &JoinReport{
+		Policy:    policy,
+		IndexOnly: []string{},
+		IVOnly:    []string{},
+		Filled:    []string{},
+		Dropped:   []string{},
+	}
+	ji := []*DailyData{}
+	jv := []*DailyData{}
+	var lastIV *DailyData
+
+	i, j := 0, 0
+	for i < len(index) || j < len(iv) {
+		switch {
+		case j >= len(iv) || (i < len(index) && index[i].date.Before(iv[j].date)):
+			// 株価指数にしかない
+			d := index[i]
+			r.IndexOnly = append(r.IndexOnly, formatDate(d.date))
+			if policy == JoinForwardFillIV && lastIV != nil {
+				filled := *lastIV
+				filled.date = d.date
+				ji = append(ji, d)
+				jv = append(jv, &filled)
+				r.Filled = append(r.Filled, formatDate(d.date))
+			} else {
+				r.Dropped = append(r.Dropped, formatDate(d.date))
+			}
+			i++
+		case i >= len(index) || iv[j].date.Before(index[i].date):
+			// IVにしかない
+			r.IVOnly = append(r.IVOnly, formatDate(iv[j].date))
+			r.Dropped = append(r.Dropped, formatDate(iv[j].date))
+			lastIV = iv[j]
+			j++
+		default:
+			ji = append(ji, index[i])
+			jv = append(jv, iv[j])
+			lastIV = iv[j]
+			i++
+			j++
+		}
+	}
+	r.Joined = len(ji)
+
+	if policy == JoinDropReport {
+		for _, d := range r.IndexOnly {
+			log.Printf("Dropped: no IV on %s", d)
+		}
+		for _, d := range r.IVOnly {
+			log.Printf("Dropped: no index on %s", d)
+		}
+	}
+
+	return ji, jv, r, nil
+}
+
+// 日付が狭義単調増加になっているか確認
+func checkSorted(ds []*DailyData) error {
+	for i := 1; i < len(ds); i++ {
+		if !ds[i-1].date.Before(ds[i].date) {
+			return fmt.Errorf("dates are not sorted: %s, %s", formatDate(ds[i-1].date), formatDate(ds[i].date))
+		}
+	}
+	return nil
+}
+
+// ほかの銘柄の価格を株価指数の日付に揃える
+// どちらも日付の昇順に並んでいること
+// 返す列は index と同じ長さで、同じ添字が同じ日付になる
+// other にない日は直前の終値で値動きのない日とし、最初の日より前は nil にする
+func AlignDailyData(index, other []*DailyData) ([]*DailyData, error) {
+	if err := checkSorted(other); err != nil {
+		return nil, err
+	}
+	acc := make([]*DailyData, len(index))
+	var last *DailyData
+	j := 0
+	for i, d := range index {
+		for j < len(other) && !other[j].date.After(d.date) {
+			last = other[j]
+			j++
+		}
+		switch {
+		case last == nil:
+		case last.date.Equal(d.date):
+			acc[i] = last
+		default:
+			acc[i] = &DailyData{
+				date:     d.date,
+				open:     last.close,
+				close:    last.close,
+				high:     last.close,
+				low:      last.close,
+				adjClose: last.adjClose,
+			}
+		}
+	}
+	return acc, nil
+}
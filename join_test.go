@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dailyDataOf(dates ...string) []*DailyData {
+	ds := []*DailyData{}
+	for i, d := range dates {
+		v := float64(i + 1)
+		ds = append(ds, &DailyData{date: date(d), open: v, close: v, high: v, low: v})
+	}
+	return ds
+}
+
+func dates(ds []*DailyData) []string {
+	acc := []string{}
+	for _, d := range ds {
+		acc = append(acc, formatDate(d.date))
+	}
+	return acc
+}
+
+func TestJoinDailyDataInner(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06", "2020-01-07")
+	iv := dailyDataOf("2020-01-02", "2020-01-06", "2020-01-07", "2020-01-08")
+
+	ji, jv, r, err := JoinDailyData(index, iv, JoinInner)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2020-01-02", "2020-01-06", "2020-01-07"}, dates(ji))
+	assert.Equal(t, dates(ji), dates(jv))
+	assert.Equal(t, 3, r.Joined)
+	assert.Equal(t, []string{"2020-01-03"}, r.IndexOnly)
+	assert.Equal(t, []string{"2020-01-08"}, r.IVOnly)
+	assert.Equal(t, []string{}, r.Filled)
+	assert.True(t, r.HasMismatch())
+}
+
+func TestJoinDailyDataForwardFillIV(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06")
+	iv := dailyDataOf("2020-01-03", "2020-01-06")
+
+	ji, jv, r, err := JoinDailyData(index, iv, JoinForwardFillIV)
+	assert.NoError(t, err)
+	// 先頭は埋める値がないので捨てる
+	assert.Equal(t, []string{"2020-01-03", "2020-01-06"}, dates(ji))
+	assert.Equal(t, []string{"2020-01-02"}, r.Dropped)
+
+	index = dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06")
+	iv = dailyDataOf("2020-01-02", "2020-01-06")
+	ji, jv, r, err = JoinDailyData(index, iv, JoinForwardFillIV)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2020-01-02", "2020-01-03", "2020-01-06"}, dates(ji))
+	assert.Equal(t, dates(ji), dates(jv))
+	assert.Equal(t, []string{"2020-01-03"}, r.Filled)
+	// 埋めた値は直前のIV
+	assert.Equal(t, 1.0, jv[1].open)
+	assert.Equal(t, 2.0, jv[2].open)
+	// 元のデータは書き換えない
+	assert.Equal(t, date("2020-01-02"), iv[0].date)
+}
+
+func TestJoinDailyDataNoMismatch(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03")
+	iv := dailyDataOf("2020-01-02", "2020-01-03")
+
+	ji, _, r, err := JoinDailyData(index, iv, JoinDropReport)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ji))
+	assert.False(t, r.HasMismatch())
+}
+
+func TestJoinDailyDataError(t *testing.T) {
+	index := dailyDataOf("2020-01-03", "2020-01-02")
+	iv := dailyDataOf("2020-01-02")
+
+	_, _, _, err := JoinDailyData(index, iv, JoinInner)
+	assert.Error(t, err)
+	_, _, _, err = JoinDailyData(iv, iv, JoinPolicy("outer"))
+	assert.Error(t, err)
+}
+
+func TestAlignDailyData(t *testing.T) {
+	index := dailyDataOf("2020-01-02", "2020-01-03", "2020-01-06", "2020-01-07")
+	other := dailyDataOf("2020-01-03", "2020-01-04", "2020-01-07")
+
+	got, err := AlignDailyData(index, other)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(got))
+	assert.Nil(t, got[0])
+	assert.Equal(t, other[0], got[1])
+	// 01-06 は 01-04 の終値で埋める
+	assert.Equal(t, "2020-01-06", formatDate(got[2].date))
+	assert.Equal(t, 2.0, got[2].open)
+	assert.Equal(t, 2.0, got[2].high)
+	assert.Equal(t, other[2], got[3])
+
+	_, err = AlignDailyData(index, dailyDataOf("2020-01-03", "2020-01-02"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// 価格変動以外の損益の内訳
+// 種類ごと、年ごとに集計する。受け取りは正、支払いは負
+type ledger struct {
+	entries map[string]map[int]float64
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		entries: map[string]map[int]float64{},
+	}
+}
+
+func (l *ledger) add(kind string, t time.Time, v float64) {
+	if l.entries[kind] == nil {
+		l.entries[kind] = map[int]float64{}
+	}
+	l.entries[kind][t.Year()] += v
+}
+
+// 記録されている種類 (名前順)
+func (l *ledger) kinds() []string {
+	ks := []string{}
+	for k := range l.entries {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// 記録されている年 (昇順)
+func (l *ledger) years(kind string) []int {
+	ys := []int{}
+	for y := range l.entries[kind] {
+		ys = append(ys, y)
+	}
+	sort.Ints(ys)
+	return ys
+}
+
+func (l *ledger) total(kind string) float64 {
+	s := 0.0
+	for _, v := range l.entries[kind] {
+		s += v
+	}
+	return s
+}
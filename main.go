@@ -2,182 +2,57 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"math"
+	"os"
+	"time"
 )
 
 func main() {
-	//index, iv := readData("19900102", "19991231")
-	index, iv := readData("20000101", "20091231")
-	//index, iv := readData("20100101", "20191231")
-	//index, iv := readData("20200101", "20200824")
-
-	// index, iv := readData("19900102", "20200814")
-	// index, iv := readData("20090101", "20200814")
-	// index, iv := readData("20090101", "20131231")
-	// index, iv := readData("20090101", "20131231")
-
-	s := NewLeverageRatioStrategy()
-	a := NewAccount()
-	initial := 300.0
-	income := 0.0
-	run(s, a, initial, income, index, iv)
-
-	// a.Dump(index[len(index)-1].close)
+	os.Exit(runCLI(os.Args[1:]))
 }
 
-// CSVを読み込む
-func readData(from, to string) (index []*DailyData, iv []*DailyData) {
-	index, err := ReadDailyData(fmt.Sprintf("./SP500_daily_%s_%s.csv", from, to))
+// CSVを読み込み、日付を揃える
+// From, To は YYYY-MM-DD 形式で、両端を含む。空文字列なら制限しない
+func readData(c DataConfig) (index []*DailyData, iv []*DailyData, jr *JoinReport, err error) {
+	from, to, err := c.period()
 	if err != nil {
-		log.Fatalf("Failed to read index csv: %v", err)
+		return nil, nil, nil, err
 	}
-	iv, err = ReadDailyData(fmt.Sprintf("./VIX_daily_%s_%s.csv", from, to))
+	opts := c.csvOptions()
+	index, err = ReadDailyDataWithOptions(c.Index, opts)
 	if err != nil {
-		log.Fatalf("Failed to read IV csv: %v", err)
+		return nil, nil, nil, fmt.Errorf("Failed to read index csv: %v", err)
 	}
-
-	if len(index) != len(iv) {
-		log.Fatalf("Mismatch of length of index and iv")
+	iv, err = ReadDailyDataWithOptions(c.IV, opts)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to read IV csv: %v", err)
 	}
 
-	return index, iv
-}
-
-// バックテストを実行
-func run(s Strategy, a *Account, initial float64, income float64, index []*DailyData, iv []*DailyData) {
-	totalDeposit := 0.0
-
-	a.Deposit(initial)
-	totalDeposit += initial
-
-	vs := []*dailyValuation{}
-
-	for i := range index {
-		d := index[i]
-		v := iv[i]
+	index = filterDailyData(index, from, to)
+	iv = filterDailyData(iv, from, to)
 
-		if d.date != v.date {
-			log.Fatalf("date mismatch: index=%s, iv=%s", d.date, v.date)
-		}
-
-		if i%21 == 0 {
-			a.Deposit(income)
-			totalDeposit += income
-		}
-		s.PrepareDay(a, d.open, v.open)
-
-		a.ExecLosscut(d.low)
-		a.ExecMarginCall(d.low)
-
-		vs = append(vs, &dailyValuation{date: d.date, valuation: a.Valuation(d.close)})
-
-		log.Printf("%s done", d.date)
+	index, iv, jr, err = JoinDailyData(index, iv, JoinPolicy(c.Join))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(index) == 0 {
+		return nil, nil, nil, fmt.Errorf("No data between %q and %q", c.From, c.To)
 	}
 
-	printStat(initial, totalDeposit, vs)
-}
-
-type dailyValuation struct {
-	date      string
-	valuation float64
+	return index, iv, jr, nil
 }
 
-// 各種統計を表示
-func printStat(initialDeposit, totalDeposit float64, vs []*dailyValuation) {
-	high := 0.0 // all-time high
-	maxDrawdown := 0.0
-
-	years := []float64{}
-	months := []float64{}
-
-	size := len(vs)
-	for i, v := range vs {
-		if i+1 == size {
-			// 最後だけの処理
-			years = append(years, v.valuation)
-			months = append(months, v.valuation)
-		} else {
-			// 最後ではない場合だけの処理
-			next := vs[i+1]
-			if v.date[:4] != next.date[:4] {
-				years = append(years, v.valuation)
-			}
-			if v.date[:7] != next.date[:7] {
-				months = append(months, v.valuation)
-			}
+// 指定した期間のデータだけを取り出す
+// from, to がゼロ値なら制限しない
+func filterDailyData(ds []*DailyData, from, to time.Time) []*DailyData {
+	acc := []*DailyData{}
+	for _, d := range ds {
+		if !from.IsZero() && d.date.Before(from) {
+			continue
 		}
-
-		// max drawdown
-		if v.valuation > high {
-			high = v.valuation
+		if !to.IsZero() && d.date.After(to) {
+			continue
 		}
-		drawdown := v.valuation/high - 1
-		if drawdown < maxDrawdown {
-			maxDrawdown = drawdown
-		}
-
-		fmt.Printf("%s\t%f\t%f\n", v.date, v.valuation/initialDeposit, drawdown)
-	}
-
-	monthlyReturns := returns(initialDeposit, months)
-	yearlyReturns := returns(initialDeposit, years)
-	fmt.Printf("%v\n", monthlyReturns)
-	fmt.Printf("%v\n", yearlyReturns)
-
-	fmt.Printf("max drawdown: %f\n", maxDrawdown)
-	fmt.Printf("total return: %f\n", vs[size-1].valuation/totalDeposit)
-	fmt.Printf("monthly return expect: %f\n", avg(monthlyReturns))
-	fmt.Printf("monthly return stdev: %f\n", stdev(monthlyReturns))
-	fmt.Printf("monthly sharp ratio: %f\n", avg(monthlyReturns)/stdev(monthlyReturns))
-	fmt.Printf("yearly return expect: %f\n", avg(yearlyReturns))
-	fmt.Printf("yearly return stdev: %f\n", stdev(yearlyReturns))
-	fmt.Printf("yearly sharp ratio: %f\n", avg(yearlyReturns)/stdev(yearlyReturns))
-	monthlyLogReturns := logReturns(initialDeposit, months)
-	yearlyLogReturns := logReturns(initialDeposit, years)
-	fmt.Printf("monthly log return expect: %f\n", math.Exp(avg(monthlyLogReturns)))
-	fmt.Printf("monthly log return stdev: %f\n", math.Exp(stdev(monthlyLogReturns)))
-	fmt.Printf("monthly log sharp ratio: %f\n", math.Exp(avg(monthlyLogReturns))/math.Exp(stdev(monthlyLogReturns)))
-	fmt.Printf("yearly log return expect: %f\n", math.Exp(avg(yearlyLogReturns)))
-	fmt.Printf("yearly log return stdev: %f\n", math.Exp(stdev(yearlyLogReturns)))
-	fmt.Printf("yearly log sharp ratio: %f\n", math.Exp(avg(yearlyLogReturns))/math.Exp(stdev(yearlyLogReturns)))
-	fmt.Printf("CAGR: %f\n", math.Exp(avg(yearlyLogReturns)))
-	fmt.Printf("Calmar ratio: %f\n", math.Exp(avg(yearlyLogReturns))/maxDrawdown)
-}
-
-func avg(vs []float64) float64 {
-	sum := 0.0
-	for _, v := range vs {
-		sum += v
-	}
-	return sum / float64(len(vs))
-}
-
-func stdev(vs []float64) float64 {
-	mu := avg(vs)
-	sum := 0.0
-	for _, v := range vs {
-		sum += math.Pow(v-mu, 2.0)
-	}
-	return math.Sqrt(sum / float64(len(vs)))
-}
-
-func returns(init float64, vs []float64) []float64 {
-	rs := []float64{}
-	prev := init
-	for _, v := range vs {
-		rs = append(rs, v/prev-1)
-		prev = v
-	}
-	return rs
-}
-
-func logReturns(init float64, vs []float64) []float64 {
-	logs := []float64{}
-	prev := init
-	for _, v := range vs {
-		logs = append(logs, math.Log(v/prev))
-		prev = v
+		acc = append(acc, d)
 	}
-	return logs
+	return acc
 }
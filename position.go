@@ -1,33 +1,119 @@
 package main
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
+
+// 売買の方向
+type Side string
+
+const (
+	Long  Side = "long"  // 買い
+	Short Side = "short" // 売り
+)
+
+func ParseSide(s string) (Side, error) {
+	switch d := Side(s); d {
+	case Long, Short:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown side: %s", s)
+}
+
+// 価格が上がったときに利益になるなら1、損失になるなら-1
+func (s Side) sign() float64 {
+	if s == Short {
+		return -1
+	}
+	return 1
+}
 
 type Position struct {
+	side           Side
 	unit           float64
+	quantity       float64 // 数量。損益と証拠金はこの倍になる
 	optionalMargin float64
+	spec           *ContractSpec
+	// 保持している Positions。ロスカット値が変わったときに並び順を直してもらう
+	owner *Positions
+	entry *item
 }
 
-// 指定した値で成行注文したときのポジション
+// 指定した値で成行注文したときの買いポジション
 func NewPosition(unit float64) *Position {
+	return NewPositionWithSide(Long, unit)
+}
+
+// 指定した値で成行注文したときの売りポジション
+func NewShortPosition(unit float64) *Position {
+	return NewPositionWithSide(Short, unit)
+}
+
+func NewPositionWithSide(side Side, unit float64) *Position {
+	return NewPositionWithSpec(side, unit, DefaultContractSpec())
+}
+
+// 取引の決まりを指定した、数量1のポジション
+func NewPositionWithSpec(side Side, unit float64, spec *ContractSpec) *Position {
+	return NewPositionWithQuantity(side, unit, 1, spec)
+}
+
+// 数量と取引の決まりを指定したポジション
+// 数量は取引単位に丸めずにそのまま使う
+func NewPositionWithQuantity(side Side, unit float64, quantity float64, spec *ContractSpec) *Position {
 	return &Position{
+		side:           side,
 		unit:           unit,
+		quantity:       quantity,
 		optionalMargin: 0,
+		spec:           spec,
 	}
 }
 
+// 売買の方向
+func (p *Position) Side() Side {
+	return p.side
+}
+
 // 建単価
 func (p *Position) Unit() float64 {
 	return p.unit
 }
 
+// 取引の決まり
+func (p *Position) Spec() *ContractSpec {
+	return p.spec
+}
+
+// 数量
+func (p *Position) Quantity() float64 {
+	return p.quantity
+}
+
+// 価格が1動いたときの損益
+func (p *Position) scale() float64 {
+	return p.spec.Multiplier * p.quantity
+}
+
+// 建単価での約定代金
+func (p *Position) notional() float64 {
+	return p.Unit() * p.scale()
+}
+
 // 評価損
 func (p *Position) ValuationLoss(current float64) float64 {
-	return math.Max(0, p.Unit()-current)
+	return math.Max(0, -p.profit(current))
+}
+
+// 評価損益
+func (p *Position) profit(current float64) float64 {
+	return (current - p.Unit()) * p.side.sign() * p.scale()
 }
 
 // 必要証拠金
 func (p *Position) RequiredMargin() float64 {
-	return p.Unit() * 0.1
+	return p.notional() * p.spec.MarginRatio
 }
 
 // 任意証拠金
@@ -40,30 +126,43 @@ func (p *Position) BoundMargin() float64 {
 	return p.RequiredMargin() + p.OptionalMargin()
 }
 
-// ロスカット幅
+// ロスカット幅 (価格)
 func (p *Position) LosscutWidth() float64 {
-	// GMOクリック証券が決める値だが、おおよそ建単価の5%になるのでそれを使う
-	return p.Unit() * 0.05
+	return p.Unit() * p.spec.LosscutRate
 }
 
 // 現在のロスカット値
+// 買いは建単価より下、売りは建単価より上になる
 func (p *Position) LosscutValue() float64 {
-	return p.Unit() - p.LosscutWidth() - p.OptionalMargin()
+	return p.Unit() - (p.LosscutWidth()+p.OptionalMargin()/p.scale())*p.side.sign()
 }
 
 // 設定可能なロスカット値の最大値
+// 買いは任意証拠金が0のとき、売りはレバレッジ1倍のとき
 func (p *Position) MaxLosscutValue() float64 {
+	if p.side == Short {
+		return p.Unit()*(2-p.spec.MarginRatio) + p.LosscutWidth()
+	}
 	return p.Unit() - p.LosscutWidth()
 }
 
 // 設定可能なロスカット値の最小値
+// 買いはレバレッジ1倍のとき、売りは任意証拠金が0のとき
 func (p *Position) MinLosscutValue() float64 {
-	return p.LosscutWidth()
+	if p.side == Short {
+		return p.Unit() + p.LosscutWidth()
+	}
+	return p.Unit()*p.spec.MarginRatio - p.LosscutWidth()
+}
+
+// 任意証拠金が0のときのロスカット値。建単価に最も近い
+func (p *Position) NearestLosscutValue() float64 {
+	return p.Unit() - p.LosscutWidth()*p.side.sign()
 }
 
 // レバレッジ倍率
 func (p *Position) Leverage() float64 {
-	return p.Unit() / p.BoundMargin()
+	return p.notional() / p.BoundMargin()
 }
 
 // 指定した値をロスカット値として設定するときに、追加で必要な証拠金を返す
@@ -77,7 +176,7 @@ func (p *Position) AdditionalMarginToLosscutValue(v float64) float64 {
 	if v < p.MinLosscutValue() {
 		v = p.MinLosscutValue()
 	}
-	return p.LosscutValue() - v
+	return (p.LosscutValue() - v) * p.side.sign() * p.scale()
 }
 
 // 指定した値をロスカット値として設定しする
@@ -86,31 +185,44 @@ func (p *Position) AdditionalMarginToLosscutValue(v float64) float64 {
 func (p *Position) SetLosscutValue(v float64) {
 	m := p.AdditionalMarginToLosscutValue(v)
 	p.optionalMargin += m
+	p.losscutValueChanged()
 }
 
 // 評価額
 // 指定した値で決済したときに未拘束残高として返ってくる金額
 func (p *Position) Valuation(current float64) float64 {
-	return p.BoundMargin() + current - p.Unit()
+	return p.BoundMargin() + p.profit(current)
 }
 
 // 指定したレバレッジ倍率に設定する
-// 1倍以下、10倍以上はそれぞれ1倍、10倍扱いとする
+// 1倍以下、上限以上はそれぞれ1倍、上限扱いとする
 func (p *Position) SetLeverage(l float64) {
 	p.optionalMargin += p.AdditionalMarginToLeverage(l)
+	p.losscutValueChanged()
+}
+
+// 任意証拠金をすべて外す
+// 外した額を返す
+func (p *Position) ClearOptionalMargin() float64 {
+	m := p.optionalMargin
+	p.optionalMargin = 0
+	p.losscutValueChanged()
+	return m
+}
+
+// 任意証拠金が変わったことを保持している Positions に知らせる
+func (p *Position) losscutValueChanged() {
+	if p.owner != nil {
+		p.owner.reorderLosscut(p.entry)
+	}
 }
 
 // 指定したレバレッジ倍率にするために追加で必要な証拠金を返す
 // 余ればマイナスとなる
-// 1倍以下、10倍以上はそれぞれ1倍、10倍扱いとする
+// 1倍以下、上限以上はそれぞれ1倍、上限扱いとする
 func (p *Position) AdditionalMarginToLeverage(l float64) float64 {
-	if l < 1 {
-		l = 1
-	}
-	if l > 10 {
-		l = 10
-	}
-	// unit / bound_margin = leverage
-	// unit / leverage = bound_margin
-	return p.Unit()/l - p.BoundMargin()
+	l = p.spec.clampLeverage(l)
+	// notional / bound_margin = leverage
+	// notional / leverage = bound_margin
+	return p.notional()/l - p.BoundMargin()
 }
@@ -122,3 +122,65 @@ func TestAdditionalMarginToLeverage(t *testing.T) {
 	assert.Equal(t, 400.0, p.AdditionalMarginToLeverage(2))
 	assert.Equal(t, 100.0, p.AdditionalMarginToLeverage(5))
 }
+
+func TestShortPosition(t *testing.T) {
+	p := NewShortPosition(1000)
+	assert.Equal(t, Short, p.Side())
+	assert.Equal(t, 100.0, p.BoundMargin())
+	// ロスカット値は建単価より上
+	assert.Equal(t, 1050.0, p.LosscutValue())
+	assert.Equal(t, 1050.0, p.MinLosscutValue())
+	assert.Equal(t, 1950.0, p.MaxLosscutValue())
+
+	assert.Equal(t, 150.0, p.Valuation(950))
+	assert.Equal(t, 50.0, p.Valuation(1050))
+	assert.Equal(t, 50.0, p.ValuationLoss(1050))
+	assert.Equal(t, 0.0, p.ValuationLoss(950))
+
+	assert.Equal(t, 100.0, p.AdditionalMarginToLosscutValue(1150))
+	assert.Equal(t, 0.0, p.AdditionalMarginToLosscutValue(900))
+	p.SetLosscutValue(1150)
+	assert.Equal(t, 1150.0, p.LosscutValue())
+	assert.Equal(t, 200.0, p.BoundMargin())
+	assert.Equal(t, -50.0, p.AdditionalMarginToLosscutValue(1100))
+
+	p.SetLeverage(1)
+	assert.Equal(t, 1950.0, p.LosscutValue())
+	assert.Equal(t, 900.0, p.ClearOptionalMargin())
+	assert.Equal(t, 1050.0, p.LosscutValue())
+}
+
+func TestPositionWithSpec(t *testing.T) {
+	spec, _ := NewContractSpecPreset("commodity-cfd")
+	spec.Multiplier = 10
+	p := NewPositionWithSpec(Long, 100, spec)
+	assert.Equal(t, spec, p.Spec())
+	assert.Equal(t, 50.0, p.RequiredMargin())
+	assert.Equal(t, 97.5, p.LosscutValue())
+	assert.Equal(t, 100.0, p.profit(110))
+
+	// ロスカット値の差は倍率をかけた証拠金になる
+	assert.Equal(t, 25.0, p.AdditionalMarginToLosscutValue(95))
+	p.SetLosscutValue(95)
+	assert.Equal(t, 75.0, p.BoundMargin())
+
+	// 上限の20倍に収める
+	p.SetLeverage(40)
+	assert.Equal(t, 20.0, p.Leverage())
+	assert.Equal(t, 97.5, p.LosscutValue())
+	p.SetLeverage(1)
+	assert.InDelta(t, p.MinLosscutValue(), p.LosscutValue(), 1e-9)
+	assert.Equal(t, 2.5, p.MinLosscutValue())
+}
+
+func TestPositionWithQuantity(t *testing.T) {
+	p := NewPositionWithQuantity(Short, 1000, 0.5, DefaultContractSpec())
+	assert.Equal(t, 0.5, p.Quantity())
+	assert.Equal(t, 50.0, p.RequiredMargin())
+	assert.Equal(t, 1050.0, p.LosscutValue())
+	assert.Equal(t, -25.0, p.profit(1050))
+	// ロスカット値を50離すには数量分の証拠金が要る
+	assert.Equal(t, 25.0, p.AdditionalMarginToLosscutValue(1100))
+	p.SetLeverage(5)
+	assert.Equal(t, 100.0, p.BoundMargin())
+}
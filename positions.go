@@ -6,12 +6,19 @@ type item struct {
 	next     *item
 	prev     *item
 	position *Position // not nil
+	// ロスカット値の順のリスト
+	lcNext *item
+	lcPrev *item
 }
 
+// 建単価の順と、ロスカット値の順の2つの双方向リストで保持する
 type Positions struct {
 	minItem *item
 	maxItem *item
 	size    int
+
+	minLosscutItem *item
+	maxLosscutItem *item
 }
 
 func NewPositions() *Positions {
@@ -22,11 +29,126 @@ func NewPositions() *Positions {
 	}
 }
 
+// ロスカット値が最大のポジションを返す。なければ nil
+// 価格が下がったときに最初にロスカットされる
+func (ps *Positions) MaxLosscut() *Position {
+	if ps.maxLosscutItem == nil {
+		return nil
+	}
+	return ps.maxLosscutItem.position
+}
+
+// ロスカット値が最小のポジションを返す。なければ nil
+func (ps *Positions) MinLosscut() *Position {
+	if ps.minLosscutItem == nil {
+		return nil
+	}
+	return ps.minLosscutItem.position
+}
+
+// ロスカット値の大きい順にポジションを返す
+func (ps *Positions) ByLosscutValue() []*Position {
+	acc := []*Position{}
+	for i := ps.maxLosscutItem; i != nil; i = i.lcPrev {
+		acc = append(acc, i.position)
+	}
+	return acc
+}
+
+// 追加したポジションを保持していることにして、ロスカット値の順のリストに入れる
+func (ps *Positions) attach(i *item) {
+	i.position.owner = ps
+	i.position.entry = i
+	ps.insertLosscut(i)
+}
+
+// 取り除いたポジションをロスカット値の順のリストから外す
+func (ps *Positions) detach(i *item) {
+	ps.removeLosscut(i)
+	i.position.owner = nil
+	i.position.entry = nil
+}
+
+// ロスカット値の順のリストに入れる
+// ロスカット値が同じなら後から入れたものを大きい側に置く
+func (ps *Positions) insertLosscut(new *item) {
+	lv := new.position.LosscutValue()
+	// 大きい側から辿る
+	i := ps.maxLosscutItem
+	for i != nil && i.position.LosscutValue() > lv {
+		i = i.lcPrev
+	}
+	// i の次に入れる。i が nil なら最小
+	new.lcPrev = i
+	if i == nil {
+		new.lcNext = ps.minLosscutItem
+		ps.minLosscutItem = new
+	} else {
+		new.lcNext = i.lcNext
+		i.lcNext = new
+	}
+	if new.lcNext != nil {
+		new.lcNext.lcPrev = new
+	} else {
+		ps.maxLosscutItem = new
+	}
+}
+
+// ロスカット値の順のリストから外す
+func (ps *Positions) removeLosscut(i *item) {
+	if i.lcPrev != nil {
+		i.lcPrev.lcNext = i.lcNext
+	} else {
+		ps.minLosscutItem = i.lcNext
+	}
+	if i.lcNext != nil {
+		i.lcNext.lcPrev = i.lcPrev
+	} else {
+		ps.maxLosscutItem = i.lcPrev
+	}
+	i.lcNext = nil
+	i.lcPrev = nil
+}
+
+// ロスカット値が変わったポジションを入れ直す
+func (ps *Positions) reorderLosscut(i *item) {
+	ps.removeLosscut(i)
+	ps.insertLosscut(i)
+}
+
+// 指定したポジションを取り除く
+// 保持していなければなにもしない
+func (ps *Positions) Remove(p *Position) {
+	i := p.entry
+	if p.owner != ps || i == nil {
+		return
+	}
+	ps.size--
+	if i.prev != nil {
+		i.prev.next = i.next
+	} else {
+		ps.minItem = i.next
+	}
+	if i.next != nil {
+		i.next.prev = i.prev
+	} else {
+		ps.maxItem = i.prev
+	}
+	ps.detach(i)
+}
+
 // ポジションの数を返す
 func (ps *Positions) Size() int {
 	return ps.size
 }
 
+// 数量の合計
+func (ps *Positions) Quantity() float64 {
+	return ps.sum(func(p *Position) float64 {
+		return p.Quantity()
+	})
+}
+
 // 建単価が最小のポジションを返す。なければ nil
 func (ps *Positions) Min() *Position {
 	if ps.minItem == nil {
@@ -43,6 +165,13 @@ func (ps *Positions) Max() *Position {
 	return ps.maxItem.position
 }
 
+// 建単価の大きい順に f を呼ぶ
+func (ps *Positions) each(f func(*Position)) {
+	for i := ps.maxItem; i != nil; i = i.prev {
+		f(i.position)
+	}
+}
+
 // ポジションを追加する
 func (ps *Positions) Add(p *Position) {
 	ps.size++
@@ -51,6 +180,7 @@ func (ps *Positions) Add(p *Position) {
 		prev:     nil,
 		position: p,
 	}
+	ps.attach(new)
 	// とりあえずひとつもない場合
 	if ps.maxItem == nil && ps.minItem == nil {
 		ps.maxItem = new
@@ -99,6 +229,7 @@ func (ps *Positions) AddMin(p *Position) error {
 		ps.maxItem = new
 	}
 	ps.minItem = new
+	ps.attach(new)
 	return nil
 }
 
@@ -121,6 +252,7 @@ func (ps *Positions) AddMax(p *Position) error {
 		ps.minItem = new
 	}
 	ps.maxItem = new
+	ps.attach(new)
 	return nil
 }
 
@@ -131,6 +263,7 @@ func (ps *Positions) RemoveMin() {
 		return
 	}
 	ps.size--
+	ps.detach(ps.minItem)
 	if ps.minItem.next != nil {
 		ps.minItem.next.prev = nil
 	} else {
@@ -146,6 +279,7 @@ func (ps *Positions) RemoveMax() {
 		return
 	}
 	ps.size--
+	ps.detach(ps.maxItem)
 	if ps.maxItem.prev != nil {
 		ps.maxItem.prev.next = nil
 	} else {
@@ -166,6 +300,13 @@ func (ps *Positions) ValuationLoss(current float64) float64 {
 	return 0
 }
 
+// 評価損益の合計
+func (ps *Positions) Profit(current float64) float64 {
+	return ps.sum(func(p *Position) float64 {
+		return p.profit(current)
+	})
+}
+
 // 評価額
 func (ps *Positions) Valuation(current float64) float64 {
 	return ps.sum(func(p *Position) float64 {
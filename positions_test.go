@@ -167,3 +167,47 @@ func TestPositionsBoundMargin(t *testing.T) {
 
 	assert.Equal(t, 100.0+120+80, ps.BoundMargin())
 }
+
+func TestPositionsByLosscutValue(t *testing.T) {
+	ps := NewPositions()
+
+	p1 := NewPosition(1000)
+	p1.SetLosscutValue(700)
+	p2 := NewPosition(900)
+	p2.SetLosscutValue(850)
+	p3 := NewPosition(1100)
+	p3.SetLosscutValue(800)
+	ps.Add(p1)
+	ps.Add(p2)
+	ps.Add(p3)
+
+	// 建単価の順とは別
+	assert.Equal(t, p3, ps.Max())
+	assert.Equal(t, p2, ps.MaxLosscut())
+	assert.Equal(t, p1, ps.MinLosscut())
+	assert.Equal(t, []*Position{p2, p3, p1}, ps.ByLosscutValue())
+
+	// 追加した後にロスカット値を変えても順番が保たれる
+	p1.SetLosscutValue(900)
+	assert.Equal(t, []*Position{p1, p2, p3}, ps.ByLosscutValue())
+	p2.SetLeverage(1)
+	assert.Equal(t, []*Position{p1, p3, p2}, ps.ByLosscutValue())
+
+	// 取り除くとどちらの順番からも消える
+	ps.Remove(p3)
+	assert.Equal(t, 2, ps.Size())
+	assert.Equal(t, p1, ps.Max())
+	assert.Equal(t, p2, ps.Min())
+	assert.Equal(t, []*Position{p1, p2}, ps.ByLosscutValue())
+
+	ps.RemoveMax()
+	assert.Equal(t, []*Position{p2}, ps.ByLosscutValue())
+	// 取り除いたものを変えても影響しない
+	p1.SetLosscutValue(500)
+	assert.Equal(t, []*Position{p2}, ps.ByLosscutValue())
+
+	ps.RemoveMin()
+	assert.Nil(t, ps.MaxLosscut())
+	assert.Nil(t, ps.MinLosscut())
+	assert.Equal(t, 0, ps.Size())
+}
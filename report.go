@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 出力されるレポート
+// 結果を後から検証できるように、実際に使われた設定を含める
+type report struct {
+	Config *Config     `json:"config"`
+	Join   *JoinReport `json:"join"`
+	Stat   *Stat       `json:"stat"`
+	// 金額の通貨。空なら通貨を区別していない
+	Currency string `json:"currency,omitempty"`
+
+	Withdrawal *withdrawalStat `json:"withdrawal,omitempty"`
+	Ledger     ledgerStat      `json:"ledger"`
+	Losscut    *losscutStat    `json:"losscut"`
+	MarginCall *marginCallStat `json:"margin_call"`
+}
+
+// 追証の結果
+type marginCallStat struct {
+	Issued   int               `json:"issued"` // 期限付きで発生した回数
+	Resolved int               `json:"resolved"`
+	Forced   int               `json:"forced"`
+	TopUp    jsonFloat         `json:"top_up"` // 追証のための入金の合計
+	Events   []*marginCallItem `json:"events"`
+}
+
+type marginCallItem struct {
+	Date      string    `json:"date"`
+	State     string    `json:"state"`
+	Valuation jsonFloat `json:"valuation"`
+	Required  jsonFloat `json:"required"`
+	Shortfall jsonFloat `json:"shortfall"`
+	Deadline  string    `json:"deadline,omitempty"`
+}
+
+func newMarginCallStat(r *result) *marginCallStat {
+	s := &marginCallStat{TopUp: jsonFloat(r.topUp), Events: []*marginCallItem{}}
+	for _, e := range r.marginCalls {
+		switch e.State {
+		case MarginCallPending:
+			s.Issued++
+		case MarginCallResolved:
+			s.Resolved++
+		case MarginCallForced:
+			s.Forced++
+		}
+		item := &marginCallItem{
+			Date:      formatDate(e.Date),
+			State:     string(e.State),
+			Valuation: jsonFloat(e.Valuation),
+			Required:  jsonFloat(e.Required),
+			Shortfall: jsonFloat(e.Shortfall()),
+		}
+		if !e.Deadline.IsZero() {
+			item.Deadline = formatDate(e.Deadline)
+		}
+		s.Events = append(s.Events, item)
+	}
+	return s
+}
+
+// ロスカットの結果
+type losscutStat struct {
+	Count       int             `json:"count"`
+	GapCount    int             `json:"gap_count"`    // 窓を開けてロスカット値より不利に約定した回数
+	SlippageSum jsonFloat       `json:"slippage_sum"` // ロスカット値と約定値の差の合計
+	SlippageMax jsonFloat       `json:"slippage_max"` // ロスカット値と約定値の差の最大
+	Events      []*losscutEntry `json:"events"`
+}
+
+type losscutEntry struct {
+	Date         string    `json:"date"`
+	Instrument   string    `json:"instrument"`
+	Side         Side      `json:"side"`
+	Unit         jsonFloat `json:"unit"`
+	LosscutValue jsonFloat `json:"losscut_value"`
+	Fill         jsonFloat `json:"fill"`
+	Slippage     jsonFloat `json:"slippage"`
+	Gap          bool      `json:"gap"`
+}
+
+func newLosscutStat(es []*LosscutEvent) *losscutStat {
+	s := &losscutStat{Events: []*losscutEntry{}}
+	for _, e := range es {
+		s.Count++
+		if e.Gap {
+			s.GapCount++
+		}
+		s.SlippageSum += jsonFloat(e.Slippage())
+		if jsonFloat(e.Slippage()) > s.SlippageMax {
+			s.SlippageMax = jsonFloat(e.Slippage())
+		}
+		s.Events = append(s.Events, &losscutEntry{
+			Date:         formatDate(e.Date),
+			Instrument:   e.Instrument,
+			Side:         e.Side,
+			Unit:         jsonFloat(e.Unit),
+			LosscutValue: jsonFloat(e.LosscutValue),
+			Fill:         jsonFloat(e.Fill),
+			Slippage:     jsonFloat(e.Slippage()),
+			Gap:          e.Gap,
+		})
+	}
+	return s
+}
+
+// 価格変動以外の損益の内訳
+type ledgerStat map[string]*ledgerItemStat
+
+type ledgerItemStat struct {
+	Total  jsonFloat            `json:"total"`
+	ByYear map[string]jsonFloat `json:"by_year"`
+}
+
+func newLedgerStat(l *ledger) ledgerStat {
+	s := ledgerStat{}
+	for _, k := range l.kinds() {
+		item := &ledgerItemStat{
+			Total:  jsonFloat(l.total(k)),
+			ByYear: map[string]jsonFloat{},
+		}
+		for _, y := range l.years(k) {
+			item.ByYear[strconv.Itoa(y)] = jsonFloat(l.entries[k][y])
+		}
+		s[k] = item
+	}
+	return s
+}
+
+// 出金の結果
+type withdrawalStat struct {
+	TotalWithdrawal jsonFloat `json:"total_withdrawal"`
+	Shortfall       jsonFloat `json:"shortfall"`
+	Ruined          bool      `json:"ruined"`
+	RuinDate        string    `json:"ruin_date,omitempty"`
+	YearsUntilRuin  jsonFloat `json:"years_until_ruin"` // 破綻していなければ null
+}
+
+func newWithdrawalStat(r *result) *withdrawalStat {
+	w := &withdrawalStat{
+		TotalWithdrawal: jsonFloat(r.totalWithdrawal),
+		Shortfall:       jsonFloat(r.shortfall),
+		Ruined:          r.ruined(),
+		YearsUntilRuin:  jsonFloat(r.yearsUntilRuin()),
+	}
+	if r.ruined() {
+		w.RuinDate = formatDate(r.ruinDate)
+	}
+	return w
+}
+
+// 結果を設定された出力先すべてに書き出す
+func writeReports(c *Config, r *result) error {
+	rep := &report{
+		Config:     c,
+		Join:       r.join,
+		Stat:       calcStat(r.initialDeposit, r.totalDeposit, r.valuations),
+		Currency:   c.Currency.Account,
+		Ledger:     newLedgerStat(r.ledger),
+		Losscut:    newLosscutStat(r.losscuts),
+		MarginCall: newMarginCallStat(r),
+	}
+	if c.Withdrawal.Rule != "" {
+		rep.Withdrawal = newWithdrawalStat(r)
+	}
+	for _, o := range c.Report.Outputs {
+		if err := writeReport(o, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReport(o *ReportOutput, rep *report) error {
+	var w io.Writer = os.Stdout
+	if o.Path != "" {
+		f, err := os.Create(o.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch o.Format {
+	case "text":
+		return writeTextReport(w, rep)
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(rep)
+	}
+	return fmt.Errorf("unknown report format: %s", o.Format)
+}
+
+// テキスト形式では、設定を YAML にしてコメントとして先頭に出力する
+func writeTextReport(w io.Writer, rep *report) error {
+	b, err := yaml.Marshal(rep.Config)
+	if err != nil {
+		return err
+	}
+	for _, l := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		fmt.Fprintf(w, "# %s\n", l)
+	}
+	if j := rep.Join; j != nil && j.HasMismatch() {
+		fmt.Fprintf(w, "# join: policy=%s, joined=%d, index_only=%v, iv_only=%v, filled=%v\n",
+			j.Policy, j.Joined, j.IndexOnly, j.IVOnly, j.Filled)
+	}
+	if rep.Currency != "" {
+		fmt.Fprintf(w, "currency: %s\n", rep.Currency)
+	}
+	printStat(w, rep.Stat)
+	printLedgerStat(w, rep.Ledger)
+	if l := rep.Losscut; l.Count != 0 {
+		fmt.Fprintf(w, "losscut: %d (gap: %d, slippage: %f, max slippage: %f)\n",
+			l.Count, l.GapCount, l.SlippageSum, l.SlippageMax)
+	}
+	if m := rep.MarginCall; len(m.Events) != 0 {
+		fmt.Fprintf(w, "margin call: issued %d, resolved %d, forced %d, top up %f\n",
+			m.Issued, m.Resolved, m.Forced, m.TopUp)
+	}
+	if ws := rep.Withdrawal; ws != nil {
+		fmt.Fprintf(w, "total withdrawal: %f\n", ws.TotalWithdrawal)
+		fmt.Fprintf(w, "withdrawal shortfall: %f\n", ws.Shortfall)
+		if ws.Ruined {
+			fmt.Fprintf(w, "ruined: %s (%f years)\n", ws.RuinDate, ws.YearsUntilRuin)
+		} else {
+			fmt.Fprintf(w, "ruined: no\n")
+		}
+	}
+	return nil
+}
+
+func printLedgerStat(w io.Writer, s ledgerStat) {
+	kinds := []string{}
+	for k := range s {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		item := s[k]
+		fmt.Fprintf(w, "%s: %f\n", k, item.Total)
+		years := []string{}
+		for y := range item.ByYear {
+			years = append(years, y)
+		}
+		sort.Strings(years)
+		for _, y := range years {
+			fmt.Fprintf(w, "  %s: %f\n", y, item.ByYear[y])
+		}
+	}
+}
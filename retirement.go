@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// 開始日をずらしながら出金計画を試した結果
+type RetirementReport struct {
+	HorizonYears       int              `json:"horizon_years"`
+	Step               Period           `json:"step"`
+	Runs               int              `json:"runs"`
+	Failures           int              `json:"failures"`
+	FailureProbability jsonFloat        `json:"failure_probability"`
+	MinYearsUntilRuin  jsonFloat        `json:"min_years_until_ruin"`    // 破綻したものの中で
+	MedianYearsToRuin  jsonFloat        `json:"median_years_until_ruin"` // 破綻したものの中で
+	Results            []*retirementRun `json:"results"`
+}
+
+type retirementRun struct {
+	Start           string    `json:"start"`
+	End             string    `json:"end"`
+	Ruined          bool      `json:"ruined"`
+	YearsUntilRuin  jsonFloat `json:"years_until_ruin"`
+	TotalWithdrawal jsonFloat `json:"total_withdrawal"`
+	FinalValuation  jsonFloat `json:"final_valuation"`
+}
+
+// 開始日を step ごとにずらし、それぞれ horizonYears 年間のバックテストを行う
+// 期間が最後まで取れない開始日は使わない
+// 設定は normalize 済みで、出金計画があること
+func simulateRetirement(c *Config, index, iv []*DailyData, horizonYears int, step Period) (*RetirementReport, error) {
+	if c.Withdrawal.Rule == "" {
+		return nil, fmt.Errorf("withdrawal.rule is required")
+	}
+	if horizonYears <= 0 {
+		return nil, fmt.Errorf("horizon must be positive: %d", horizonYears)
+	}
+	rep := &RetirementReport{
+		HorizonYears: horizonYears,
+		Step:         step,
+		Results:      []*retirementRun{},
+	}
+	last := index[len(index)-1].date
+	ruinYears := []float64{}
+	// 補助のデータはすべての開始日で共有する
+	aux, err := loadAuxData(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range index {
+		if i != 0 && step.Same(index[i-1].date, index[i].date) {
+			continue
+		}
+		start := index[i].date
+		end := start.AddDate(horizonYears, 0, 0)
+		if end.After(last.AddDate(0, 0, 1)) {
+			break
+		}
+		// 終了日は含まない
+		to := end.AddDate(0, 0, -1)
+
+		cc := *c
+		cc.Data.From = formatDate(start)
+		cc.Data.To = formatDate(to)
+		cc.Withdrawal.Start = ""
+		b, err := newBacktestWithData(&cc, filterDailyData(index, start, to), filterDailyData(iv, start, to), aux)
+		if err != nil {
+			return nil, err
+		}
+		r := b.run()
+
+		rep.Runs++
+		if r.ruined() {
+			rep.Failures++
+			ruinYears = append(ruinYears, r.yearsUntilRuin())
+		}
+		rep.Results = append(rep.Results, &retirementRun{
+			Start:           formatDate(start),
+			End:             formatDate(to),
+			Ruined:          r.ruined(),
+			YearsUntilRuin:  jsonFloat(r.yearsUntilRuin()),
+			TotalWithdrawal: jsonFloat(r.totalWithdrawal),
+			FinalValuation:  jsonFloat(r.valuations[len(r.valuations)-1].valuation),
+		})
+	}
+	if rep.Runs == 0 {
+		return nil, fmt.Errorf("data is shorter than %d years", horizonYears)
+	}
+
+	rep.FailureProbability = jsonFloat(float64(rep.Failures) / float64(rep.Runs))
+	rep.MinYearsUntilRuin = jsonFloat(math.NaN())
+	rep.MedianYearsToRuin = jsonFloat(math.NaN())
+	if len(ruinYears) != 0 {
+		sort.Float64s(ruinYears)
+		rep.MinYearsUntilRuin = jsonFloat(ruinYears[0])
+		rep.MedianYearsToRuin = jsonFloat(median(ruinYears))
+	}
+	return rep, nil
+}
+
+// 昇順に並んだ値の中央値
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func printRetirementReport(w io.Writer, rep *RetirementReport) {
+	for _, r := range rep.Results {
+		ruin := "-"
+		if r.Ruined {
+			ruin = fmt.Sprintf("%f", r.YearsUntilRuin)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%f\t%f\n", r.Start, r.End, ruin, r.TotalWithdrawal, r.FinalValuation)
+	}
+	fmt.Fprintf(w, "horizon: %d years, step: %s\n", rep.HorizonYears, rep.Step)
+	fmt.Fprintf(w, "runs: %d\n", rep.Runs)
+	fmt.Fprintf(w, "failures: %d\n", rep.Failures)
+	fmt.Fprintf(w, "failure probability: %f\n", rep.FailureProbability)
+	fmt.Fprintf(w, "min years until ruin: %f\n", rep.MinYearsUntilRuin)
+	fmt.Fprintf(w, "median years until ruin: %f\n", rep.MedianYearsToRuin)
+}
+
+func printRetirementReportJSON(w io.Writer, c *Config, rep *RetirementReport) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(struct {
+		Config     *Config           `json:"config"`
+		Retirement *RetirementReport `json:"retirement"`
+	}{c, rep})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+type dailyValuation struct {
+	date       time.Time
+	valuation  float64
+	deposit    float64 // その日までの入金の累計
+	withdrawal float64 // その日までの出金の累計
+}
+
+// JSON に出力する浮動小数点数
+// NaN や ±Inf は JSON で表現できないので null にする
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(v)
+}
+
+// null は NaN にする
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = jsonFloat(math.NaN())
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// 日毎の統計
+type dailyStat struct {
+	Date     string    `json:"date"`
+	Ratio    jsonFloat `json:"ratio"` // 初回入金額に対する評価額の比
+	Drawdown jsonFloat `json:"drawdown"`
+}
+
+// バックテスト結果の統計
+type Stat struct {
+	Daily          []*dailyStat `json:"daily"`
+	MonthlyReturns []jsonFloat  `json:"monthly_returns"`
+	YearlyReturns  []jsonFloat  `json:"yearly_returns"`
+
+	MaxDrawdown            jsonFloat `json:"max_drawdown"`
+	TotalReturn            jsonFloat `json:"total_return"`
+	MonthlyReturnExpect    jsonFloat `json:"monthly_return_expect"`
+	MonthlyReturnStdev     jsonFloat `json:"monthly_return_stdev"`
+	MonthlySharpRatio      jsonFloat `json:"monthly_sharp_ratio"`
+	YearlyReturnExpect     jsonFloat `json:"yearly_return_expect"`
+	YearlyReturnStdev      jsonFloat `json:"yearly_return_stdev"`
+	YearlySharpRatio       jsonFloat `json:"yearly_sharp_ratio"`
+	MonthlyLogReturnExpect jsonFloat `json:"monthly_log_return_expect"`
+	MonthlyLogReturnStdev  jsonFloat `json:"monthly_log_return_stdev"`
+	MonthlyLogSharpRatio   jsonFloat `json:"monthly_log_sharp_ratio"`
+	YearlyLogReturnExpect  jsonFloat `json:"yearly_log_return_expect"`
+	YearlyLogReturnStdev   jsonFloat `json:"yearly_log_return_stdev"`
+	YearlyLogSharpRatio    jsonFloat `json:"yearly_log_sharp_ratio"`
+	CAGR                   jsonFloat `json:"cagr"`
+	CalmarRatio            jsonFloat `json:"calmar_ratio"`
+}
+
+// 各種統計を計算
+func calcStat(initialDeposit, totalDeposit float64, vs []*dailyValuation) *Stat {
+	s := &Stat{}
+
+	high := 0.0 // all-time high
+	maxDrawdown := 0.0
+
+	years := []float64{}
+	months := []float64{}
+
+	size := len(vs)
+	for i, v := range vs {
+		if i+1 == size {
+			// 最後だけの処理
+			years = append(years, v.valuation)
+			months = append(months, v.valuation)
+		} else {
+			// 最後ではない場合だけの処理
+			next := vs[i+1]
+			if !Year.Same(v.date, next.date) {
+				years = append(years, v.valuation)
+			}
+			if !Month.Same(v.date, next.date) {
+				months = append(months, v.valuation)
+			}
+		}
+
+		// max drawdown
+		if v.valuation > high {
+			high = v.valuation
+		}
+		drawdown := v.valuation/high - 1
+		if drawdown < maxDrawdown {
+			maxDrawdown = drawdown
+		}
+
+		s.Daily = append(s.Daily, &dailyStat{
+			Date:     formatDate(v.date),
+			Ratio:    jsonFloat(v.valuation / initialDeposit),
+			Drawdown: jsonFloat(drawdown),
+		})
+	}
+
+	monthlyReturns := returns(initialDeposit, months)
+	yearlyReturns := returns(initialDeposit, years)
+	s.MonthlyReturns = toJSONFloats(monthlyReturns)
+	s.YearlyReturns = toJSONFloats(yearlyReturns)
+
+	s.MaxDrawdown = jsonFloat(maxDrawdown)
+	s.TotalReturn = jsonFloat(vs[size-1].valuation / totalDeposit)
+	s.MonthlyReturnExpect = jsonFloat(avg(monthlyReturns))
+	s.MonthlyReturnStdev = jsonFloat(stdev(monthlyReturns))
+	s.MonthlySharpRatio = jsonFloat(avg(monthlyReturns) / stdev(monthlyReturns))
+	s.YearlyReturnExpect = jsonFloat(avg(yearlyReturns))
+	s.YearlyReturnStdev = jsonFloat(stdev(yearlyReturns))
+	s.YearlySharpRatio = jsonFloat(avg(yearlyReturns) / stdev(yearlyReturns))
+	monthlyLogReturns := logReturns(initialDeposit, months)
+	yearlyLogReturns := logReturns(initialDeposit, years)
+	s.MonthlyLogReturnExpect = jsonFloat(math.Exp(avg(monthlyLogReturns)))
+	s.MonthlyLogReturnStdev = jsonFloat(math.Exp(stdev(monthlyLogReturns)))
+	s.MonthlyLogSharpRatio = jsonFloat(math.Exp(avg(monthlyLogReturns)) / math.Exp(stdev(monthlyLogReturns)))
+	s.YearlyLogReturnExpect = jsonFloat(math.Exp(avg(yearlyLogReturns)))
+	s.YearlyLogReturnStdev = jsonFloat(math.Exp(stdev(yearlyLogReturns)))
+	s.YearlyLogSharpRatio = jsonFloat(math.Exp(avg(yearlyLogReturns)) / math.Exp(stdev(yearlyLogReturns)))
+	s.CAGR = jsonFloat(math.Exp(avg(yearlyLogReturns)))
+	s.CalmarRatio = jsonFloat(math.Exp(avg(yearlyLogReturns)) / maxDrawdown)
+
+	return s
+}
+
+// 各種統計をテキストで表示
+func printStat(w io.Writer, s *Stat) {
+	for _, d := range s.Daily {
+		fmt.Fprintf(w, "%s\t%f\t%f\n", d.Date, d.Ratio, d.Drawdown)
+	}
+
+	fmt.Fprintf(w, "%v\n", s.MonthlyReturns)
+	fmt.Fprintf(w, "%v\n", s.YearlyReturns)
+
+	fmt.Fprintf(w, "max drawdown: %f\n", s.MaxDrawdown)
+	fmt.Fprintf(w, "total return: %f\n", s.TotalReturn)
+	fmt.Fprintf(w, "monthly return expect: %f\n", s.MonthlyReturnExpect)
+	fmt.Fprintf(w, "monthly return stdev: %f\n", s.MonthlyReturnStdev)
+	fmt.Fprintf(w, "monthly sharp ratio: %f\n", s.MonthlySharpRatio)
+	fmt.Fprintf(w, "yearly return expect: %f\n", s.YearlyReturnExpect)
+	fmt.Fprintf(w, "yearly return stdev: %f\n", s.YearlyReturnStdev)
+	fmt.Fprintf(w, "yearly sharp ratio: %f\n", s.YearlySharpRatio)
+	fmt.Fprintf(w, "monthly log return expect: %f\n", s.MonthlyLogReturnExpect)
+	fmt.Fprintf(w, "monthly log return stdev: %f\n", s.MonthlyLogReturnStdev)
+	fmt.Fprintf(w, "monthly log sharp ratio: %f\n", s.MonthlyLogSharpRatio)
+	fmt.Fprintf(w, "yearly log return expect: %f\n", s.YearlyLogReturnExpect)
+	fmt.Fprintf(w, "yearly log return stdev: %f\n", s.YearlyLogReturnStdev)
+	fmt.Fprintf(w, "yearly log sharp ratio: %f\n", s.YearlyLogSharpRatio)
+	fmt.Fprintf(w, "CAGR: %f\n", s.CAGR)
+	fmt.Fprintf(w, "Calmar ratio: %f\n", s.CalmarRatio)
+}
+
+func toJSONFloats(vs []float64) []jsonFloat {
+	fs := make([]jsonFloat, len(vs))
+	for i, v := range vs {
+		fs[i] = jsonFloat(v)
+	}
+	return fs
+}
+
+func avg(vs []float64) float64 {
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+func stdev(vs []float64) float64 {
+	mu := avg(vs)
+	sum := 0.0
+	for _, v := range vs {
+		sum += math.Pow(v-mu, 2.0)
+	}
+	return math.Sqrt(sum / float64(len(vs)))
+}
+
+func returns(init float64, vs []float64) []float64 {
+	rs := []float64{}
+	prev := init
+	for _, v := range vs {
+		rs = append(rs, v/prev-1)
+		prev = v
+	}
+	return rs
+}
+
+func logReturns(init float64, vs []float64) []float64 {
+	logs := []float64{}
+	prev := init
+	for _, v := range vs {
+		logs = append(logs, math.Log(v/prev))
+		prev = v
+	}
+	return logs
+}
@@ -1,35 +1,129 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"math"
 )
 
+// 売買の戦略
+// バックテストは OnStart、日ごとの OnBar と約定・ロスカット・追証の通知、OnEnd の順に呼び出す
+// 通知は種類ごとに起きた順に届く。通知の中では取引せず、次の OnBar で反応すること
 type Strategy interface {
-	PrepareDay(a *Account, index float64, iv float64)
+	// 初回の入金の後、最初の日の前に呼ばれる
+	OnStart(a *Account)
+	// 日ごとに始値で呼ばれる
+	OnBar(c *BarContext)
+	// 成行注文が約定した
+	OnFill(e *FillEvent)
+	// ロスカットされた
+	OnLosscut(e *LosscutEvent)
+	// 追証が発生、解消、または強制決済された
+	OnMarginCall(e *MarginCallEvent)
+	// 最後の日の後に呼ばれる
+	OnEnd(a *Account)
 }
 
+// 過去のデータがそろうまで判断が定まらない戦略
+// Lookback はそれに必要な営業日数を返す。ウォークフォワード分析では、その日数だけ前から始めて準備する
+type lookbackStrategy interface {
+	Lookback() int
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func maxInt(ns ...int) int {
+	m := 0
+	for _, n := range ns {
+		if n > m {
+			m = n
+		}
+	}
+	return m
+}
+
+// 何もしない Strategy の実装
+// 埋め込めば、必要なメソッドだけを実装すればよい
+type BaseStrategy struct{}
+
+func (BaseStrategy) OnStart(a *Account)              {}
+func (BaseStrategy) OnBar(c *BarContext)             {}
+func (BaseStrategy) OnFill(e *FillEvent)             {}
+func (BaseStrategy) OnLosscut(e *LosscutEvent)       {}
+func (BaseStrategy) OnMarginCall(e *MarginCallEvent) {}
+func (BaseStrategy) OnEnd(a *Account)                {}
+
 // losscut value strategy
 
 type LosscutValueStrategy struct {
+	BaseStrategy
+	variant string
 	indexMA *MA
 	ivMA    *MA
 }
 
+// LosscutValueStrategy のパラメータ
+type LosscutValueParams struct {
+	Variant string // ロスカット値の式 (v1, v2, grow-position)
+	IndexMA int    // 株価指数の移動平均の期間
+	IVMA    int    // IVの移動平均の期間
+}
+
+func DefaultLosscutValueParams() LosscutValueParams {
+	return LosscutValueParams{
+		Variant: "v1",
+		IndexMA: 40,
+		IVMA:    20,
+	}
+}
+
+// ロスカット値の式
+var losscutValueVariants = []string{"v1", "v2", "grow-position"}
+
 func NewLosscutValueStrategy() *LosscutValueStrategy {
-	return &LosscutValueStrategy{
-		indexMA: NewMA(40),
-		ivMA:    NewMA(20),
+	s, err := NewLosscutValueStrategyWithParams(DefaultLosscutValueParams())
+	if err != nil {
+		log.Panic(err)
 	}
+	return s
 }
 
-func (l *LosscutValueStrategy) PrepareDay(a *Account, index float64, iv float64) {
-	l.indexMA.Push(index)
+// 知らない式ならエラー
+func NewLosscutValueStrategyWithParams(p LosscutValueParams) (*LosscutValueStrategy, error) {
+	if !containsString(losscutValueVariants, p.Variant) {
+		return nil, fmt.Errorf("unknown losscut-value variant: %q", p.Variant)
+	}
+	return &LosscutValueStrategy{
+		variant: p.Variant,
+		indexMA: NewMA(p.IndexMA),
+		ivMA:    NewMA(p.IVMA),
+	}, nil
+}
+
+func (l *LosscutValueStrategy) Lookback() int {
+	return maxInt(l.indexMA.max, l.ivMA.max)
+}
+
+func (l *LosscutValueStrategy) OnBar(c *BarContext) {
+	a := c.Account
+	signal := c.Last(SeriesIndex)
+	iv := c.Last(SeriesIV)
+	if math.IsNaN(signal) || math.IsNaN(iv) {
+		return
+	}
+	l.indexMA.Push(signal)
 	l.ivMA.Push(iv)
 
-	// NOTE: VIXのほうが早いため、これは現実には不可能
-	lv := l.calcLosscutValue(index, iv)
+	lv := l.calcLosscutValue(signal, iv)
 
+	index := c.Price
 	nokosu := true
 	if nokosu {
 		if a.positions.ValuationLoss(index) > 0 {
@@ -47,7 +141,8 @@ func (l *LosscutValueStrategy) calcLosscutValue(index float64, iv float64) float
 	avgIndex := l.indexMA.Average()
 	avgIV := l.ivMA.Average()
 
-	v1 := func() float64 {
+	switch l.variant {
+	case "v1":
 		percent := 0.0
 		if iv > avgIV {
 			percent = 100 - (iv-10)*10
@@ -55,10 +150,7 @@ func (l *LosscutValueStrategy) calcLosscutValue(index float64, iv float64) float
 			percent = 100 - (iv-20)*1
 		}
 		return avgIndex * percent / 100
-	}()
-	_ = v1
-
-	v2 := func() float64 {
+	case "v2":
 		percent := 0.0
 		if iv > avgIV {
 			percent = 100 - (iv-10)*10
@@ -72,42 +164,85 @@ func (l *LosscutValueStrategy) calcLosscutValue(index float64, iv float64) float
 			i = index * 0.95
 		}
 		return i * percent / 100
-	}()
-	_ = v2
-
-	growPosition := func() float64 {
+	case "grow-position":
 		return avgIndex * (1 - (iv+32)/16*5/100)
-	}()
-	_ = growPosition
-
-	return v1
+	}
+	log.Panicf("unknown losscut-value variant: %q", l.variant)
+	return 0
 }
 
 // leverage rate strategy
 
 type LeverageRatioStrategy struct {
+	BaseStrategy
+	params   LeverageRatioParams
 	indexMA  *MA
 	ivMA     *MA
-	ivMALong *MA
 	bullDays int
 }
 
+// LeverageRatioStrategy のパラメータ
+type LeverageRatioParams struct {
+	Variant        string  // レバレッジの式 (fullpower, target-volatility, v1, v1signal, v2, v3, kelly)
+	IndexMA        int     // 株価指数の移動平均の期間
+	IVMA           int     // IVの移動平均の期間
+	Base           float64 // fullpower, v1, v1signal, v2 のレバレッジの上限
+	Slope          float64 // v2 で、IVが平均より高いときにレバレッジを下げる傾きの分母
+	ExpectedReturn float64 // kelly の株価指数の期待リターン (年率)
+}
+
+func DefaultLeverageRatioParams() LeverageRatioParams {
+	return LeverageRatioParams{
+		Variant:        "v2",
+		IndexMA:        40,
+		IVMA:           20,
+		Base:           10,
+		Slope:          1.6,
+		ExpectedReturn: 0.07,
+	}
+}
+
+// レバレッジの式
+var leverageRatioVariants = []string{"fullpower", "target-volatility", "v1", "v1signal", "v2", "v3", "kelly"}
+
 func NewLeverageRatioStrategy() *LeverageRatioStrategy {
+	s, err := NewLeverageRatioStrategyWithParams(DefaultLeverageRatioParams())
+	if err != nil {
+		log.Panic(err)
+	}
+	return s
+}
+
+// 知らない式ならエラー
+func NewLeverageRatioStrategyWithParams(p LeverageRatioParams) (*LeverageRatioStrategy, error) {
+	if !containsString(leverageRatioVariants, p.Variant) {
+		return nil, fmt.Errorf("unknown leverage-ratio variant: %q", p.Variant)
+	}
 	return &LeverageRatioStrategy{
-		indexMA:  NewMA(40),
-		ivMA:     NewMA(20),
-		ivMALong: NewMA(200),
+		params:   p,
+		indexMA:  NewMA(p.IndexMA),
+		ivMA:     NewMA(p.IVMA),
 		bullDays: 1,
-	}
+	}, nil
 }
 
-func (l *LeverageRatioStrategy) PrepareDay(a *Account, index float64, iv float64) {
-	l.indexMA.Push(index)
+func (l *LeverageRatioStrategy) Lookback() int {
+	return maxInt(l.indexMA.max, l.ivMA.max)
+}
+
+func (l *LeverageRatioStrategy) OnBar(c *BarContext) {
+	a := c.Account
+	signal := c.Last(SeriesIndex)
+	iv := c.Last(SeriesIV)
+	if math.IsNaN(signal) || math.IsNaN(iv) {
+		return
+	}
+	l.indexMA.Push(signal)
 	l.ivMA.Push(iv)
-	l.ivMALong.Push(iv)
 
 	lr := l.calcLeverageRatio(iv)
 
+	index := c.Price
 	nokosu := true
 	if nokosu {
 		a.SetLeverageWithClose2(index, lr)
@@ -120,19 +255,16 @@ func (l *LeverageRatioStrategy) PrepareDay(a *Account, index float64, iv float64
 
 func (l *LeverageRatioStrategy) calcLeverageRatio(iv float64) float64 {
 	avgIV := l.ivMA.Average()
+	base := l.params.Base
 
-	fullpower := func() float64 {
-		return 10.0
-	}()
-	_ = fullpower
+	switch l.params.Variant {
+	case "fullpower":
+		return base
 
-	targetVolatility := func() float64 {
+	case "target-volatility":
 		return 100 / iv
-	}()
-	_ = targetVolatility
 
-	v1 := func() float64 {
-		base := 10.0
+	case "v1":
 		if iv > avgIV {
 			// 低め
 			return base - (iv - 5)
@@ -143,11 +275,8 @@ func (l *LeverageRatioStrategy) calcLeverageRatio(iv float64) float64 {
 			// 1を足す <- ？
 			return base - (iv-5)/5
 		}
-	}
-	_ = v1
 
-	v1signal := func() float64 {
-		base := 10.0
+	case "v1signal":
 		log.Printf("bull days: %d", l.bullDays)
 		if l.bullDays >= 0 {
 			if iv > avgIV*(1.02-float64(l.bullDays)/50.0) {
@@ -166,41 +295,8 @@ func (l *LeverageRatioStrategy) calcLeverageRatio(iv float64) float64 {
 				return base - (iv-5)/5
 			}
 		}
-	}
-	_ = v1signal
-
-	v2 := func() float64 {
-		//adjust := math.Sqrt(l.ivMALong.Average())
-		adjust := math.Sqrt(iv)
-		base := 10.0
-		if iv > avgIV {
-			return base - (iv-adjust)/(1.6-adjust/10)
-		} else {
-			return base - (iv-adjust)/(30.0/adjust)
-		}
-		/*
-			if l.bullDays >= 0 {
-				if iv > avgIV*(1.02-float64(l.bullDays)/50.0) {
-					l.bullDays = -1
-					return base - (iv - adjust)
-				} else {
-					l.bullDays++
-					return base - (iv-adjust)/5
-				}
-			} else {
-				if iv > avgIV*(0.98-float64(l.bullDays)/50.0) {
-					l.bullDays--
-					return base - (iv - adjust)
-				} else {
-					l.bullDays = 1
-					return base - (iv-adjust)/5
-				}
-			}
-		*/
-	}
-	_ = v2
 
-	v3 := func() float64 {
+	case "v3":
 		// IVによって追証発生時の影響度が変わるため、傾斜をかける
 		// だいたい1~5の範囲
 		sigma := math.Log2(iv/5) + 1
@@ -216,14 +312,108 @@ func (l *LeverageRatioStrategy) calcLeverageRatio(iv float64) float64 {
 		// 問題点
 		// VIXがすごく低いのに少し上昇するとレバレッジを抑えがち (2019年)
 		// (2011)
-	}
-	_ = v3
 
-	kelly := func() float64 {
+	case "kelly":
 		// (mu - r) / s ^ 2
-		return (math.Pow(1.07, 1.0/252.0) - 1.0) / math.Pow(iv/math.Sqrt(252.0)/100.0, 2.0)
+		return (math.Pow(1+l.params.ExpectedReturn, 1.0/252.0) - 1.0) / math.Pow(iv/math.Sqrt(252.0)/100.0, 2.0)
+
+	case "v2":
+		adjust := math.Sqrt(iv)
+		if iv > avgIV {
+			return base - (iv-adjust)/(l.params.Slope-adjust/10)
+		} else {
+			return base - (iv-adjust)/(30.0/adjust)
+		}
+	}
+	log.Panicf("unknown leverage-ratio variant: %q", l.params.Variant)
+	return 0
+}
+
+// 戦略の登録
+
+func init() {
+	maParams := func() []*ParamSpec {
+		return []*ParamSpec{
+			{Name: "index_ma", Type: ParamInt, Default: 40, Min: 1, Max: 5000, Description: "株価指数の移動平均の期間"},
+			{Name: "iv_ma", Type: ParamInt, Default: 20, Min: 1, Max: 5000, Description: "IVの移動平均の期間"},
+		}
 	}
-	_ = kelly
 
-	return v2()
+	for _, v := range []struct {
+		variant     string
+		description string
+	}{
+		{"v1", "IVが平均より高ければ株価指数の平均から大きく離す"},
+		{"v2", "株価指数の平均と始値の95%の低いほうを基準にする"},
+		{"grow-position", "IVが高いほど株価指数の平均から離す"},
+	} {
+		variant := v.variant
+		RegisterStrategy(&StrategyEntry{
+			Name:        "losscut-value/" + variant,
+			Description: "ロスカット値を決めて建てられるだけ建てる: " + v.description,
+			Params:      maParams(),
+			New: func(p map[string]float64) (Strategy, error) {
+				s, err := NewLosscutValueStrategyWithParams(LosscutValueParams{
+					Variant: variant,
+					IndexMA: int(p["index_ma"]),
+					IVMA:    int(p["iv_ma"]),
+				})
+				if err != nil {
+					return nil, err
+				}
+				return s, nil
+			},
+		})
+	}
+	RegisterStrategyAlias("losscut-value", "losscut-value/"+DefaultLosscutValueParams().Variant)
+
+	base := &ParamSpec{Name: "base", Type: ParamFloat, Default: 10, Min: 1, Max: 25, Description: "レバレッジの上限"}
+	for _, v := range []struct {
+		variant     string
+		description string
+		params      []*ParamSpec
+	}{
+		{"fullpower", "常に base 倍", []*ParamSpec{base}},
+		{"target-volatility", "IVに反比例させる", nil},
+		{"v1", "IVが平均より高ければ大きく下げる", []*ParamSpec{base}},
+		{"v1signal", "v1 の平均との比較に、IVが下がり続けた日数で傾斜をかける", []*ParamSpec{base}},
+		{"v2", "IVの平方根で調整して下げる", []*ParamSpec{base,
+			{Name: "slope", Type: ParamFloat, Default: 1.6, Min: 1, Max: 10, Description: "IVが平均より高いときに下げる傾きの分母"}}},
+		{"v3", "1日の予想騰落率で追証が起きない程度にし、IVの上昇中は下げる", nil},
+		{"kelly", "ケリー基準", []*ParamSpec{
+			{Name: "expected_return", Type: ParamFloat, Default: 0.07, Min: -0.5, Max: 1, Description: "株価指数の期待リターン (年率)"}}},
+	} {
+		variant := v.variant
+		RegisterStrategy(&StrategyEntry{
+			Name:        "leverage-ratio/" + variant,
+			Description: "レバレッジを決めて建てる: " + v.description,
+			Params:      append(maParams(), v.params...),
+			New: func(p map[string]float64) (Strategy, error) {
+				d := DefaultLeverageRatioParams()
+				lp := LeverageRatioParams{
+					Variant:        variant,
+					IndexMA:        int(p["index_ma"]),
+					IVMA:           int(p["iv_ma"]),
+					Base:           d.Base,
+					Slope:          d.Slope,
+					ExpectedReturn: d.ExpectedReturn,
+				}
+				if b, ok := p["base"]; ok {
+					lp.Base = b
+				}
+				if s, ok := p["slope"]; ok {
+					lp.Slope = s
+				}
+				if r, ok := p["expected_return"]; ok {
+					lp.ExpectedReturn = r
+				}
+				s, err := NewLeverageRatioStrategyWithParams(lp)
+				if err != nil {
+					return nil, err
+				}
+				return s, nil
+			},
+		})
+	}
+	RegisterStrategyAlias("leverage-ratio", "leverage-ratio/"+DefaultLeverageRatioParams().Variant)
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 戦略のパラメータの型
+type ParamType string
+
+const (
+	ParamInt   ParamType = "int"
+	ParamFloat ParamType = "float"
+)
+
+// 戦略のパラメータの定義
+type ParamSpec struct {
+	Name        string
+	Type        ParamType
+	Default     float64
+	Min         float64 // この値を含む
+	Max         float64 // この値を含む
+	Description string
+}
+
+// 値が型と範囲に合っているか確認する
+func (s *ParamSpec) validate(strategy string, v float64) error {
+	if s.Type == ParamInt && v != math.Trunc(v) {
+		return fmt.Errorf("%s.%s must be an integer: %v", strategy, s.Name, v)
+	}
+	if v < s.Min || v > s.Max {
+		return fmt.Errorf("%s.%s must be in [%v, %v]: %v", strategy, s.Name, s.Min, s.Max, v)
+	}
+	return nil
+}
+
+// 登録された戦略
+// 名前は "戦略/式" の形式で、同じ戦略でも式ごとに登録する
+type StrategyEntry struct {
+	Name        string
+	Description string
+	Params      []*ParamSpec
+	// 作るたびに新しい状態の戦略を返すこと。パラメータはすべて埋めて渡す
+	New func(p map[string]float64) (Strategy, error)
+}
+
+var (
+	strategyRegistry = map[string]*StrategyEntry{}
+	// 式を省略した名前と、そのとき使う名前
+	strategyAliases = map[string]string{}
+)
+
+// 戦略を登録する。同じ名前を二度登録すると panic する
+func RegisterStrategy(e *StrategyEntry) {
+	if _, ok := strategyRegistry[e.Name]; ok {
+		panic(fmt.Sprintf("strategy already registered: %s", e.Name))
+	}
+	for _, p := range e.Params {
+		if err := p.validate(e.Name, p.Default); err != nil {
+			panic(fmt.Sprintf("invalid default: %v", err))
+		}
+	}
+	strategyRegistry[e.Name] = e
+}
+
+// 名前 alias で name の戦略を選べるようにする
+func RegisterStrategyAlias(alias, name string) {
+	if _, ok := strategyRegistry[name]; !ok {
+		panic(fmt.Sprintf("unknown strategy: %s", name))
+	}
+	strategyAliases[alias] = name
+}
+
+// 登録された戦略の名前 (名前順)。別名は含まない
+func StrategyNames() []string {
+	ns := []string{}
+	for n := range strategyRegistry {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// 別名なら登録された名前にする
+func canonicalStrategyName(name string) string {
+	if n, ok := strategyAliases[name]; ok {
+		return n
+	}
+	return name
+}
+
+// 名前か別名から戦略を探す
+func LookupStrategy(name string) (*StrategyEntry, error) {
+	name = canonicalStrategyName(name)
+	e, ok := strategyRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: %s (available: %s)", name, strings.Join(StrategyNames(), ", "))
+	}
+	return e, nil
+}
+
+// パラメータを検証し、省略されたものはデフォルト値で埋めて返す
+func (e *StrategyEntry) normalizeParams(params map[string]float64) (map[string]float64, error) {
+	specs := map[string]*ParamSpec{}
+	acc := map[string]float64{}
+	for _, p := range e.Params {
+		specs[p.Name] = p
+		acc[p.Name] = p.Default
+	}
+	keys := []string{}
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p, ok := specs[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter for %s: %s", e.Name, k)
+		}
+		if err := p.validate(e.Name, params[k]); err != nil {
+			return nil, err
+		}
+		acc[k] = params[k]
+	}
+	return acc, nil
+}
+
+// 名前とパラメータから戦略を作る
+func NewStrategyByName(name string, params map[string]float64) (Strategy, error) {
+	e, err := LookupStrategy(name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := e.normalizeParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return e.New(p)
+}
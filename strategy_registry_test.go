@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupStrategy(t *testing.T) {
+	e, err := LookupStrategy("leverage-ratio")
+	assert.NoError(t, err)
+	assert.Equal(t, "leverage-ratio/v2", e.Name)
+
+	e, err = LookupStrategy("losscut-value/v2")
+	assert.NoError(t, err)
+	assert.Equal(t, "losscut-value/v2", e.Name)
+
+	_, err = LookupStrategy("leverage-ratio/v9")
+	assert.Error(t, err)
+}
+
+func TestStrategyParams(t *testing.T) {
+	e, _ := LookupStrategy("leverage-ratio/v2")
+
+	p, err := e.normalizeParams(map[string]float64{"iv_ma": 10, "slope": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"index_ma": 40, "iv_ma": 10, "base": 10, "slope": 2}, p)
+
+	_, err = e.normalizeParams(map[string]float64{"expected_return": 0.05})
+	assert.Error(t, err)
+	_, err = e.normalizeParams(map[string]float64{"iv_ma": 1.5})
+	assert.Error(t, err)
+	_, err = e.normalizeParams(map[string]float64{"base": 30})
+	assert.Error(t, err)
+
+	s, err := NewStrategyByName("leverage-ratio/v2", map[string]float64{"base": 8})
+	assert.NoError(t, err)
+	l := s.(*LeverageRatioStrategy)
+	assert.Equal(t, 8.0, l.params.Base)
+	assert.Equal(t, 1.6, l.params.Slope)
+	assert.Equal(t, "v2", l.params.Variant)
+}
+
+func TestStrategyVariants(t *testing.T) {
+	// 登録したものはすべて作れる
+	for _, name := range StrategyNames() {
+		_, err := NewStrategyByName(name, nil)
+		assert.NoError(t, err, name)
+	}
+
+	// 知らない式は、既定の式で代用せずエラーにする
+	lp := DefaultLeverageRatioParams()
+	lp.Variant = "v3 "
+	_, err := NewLeverageRatioStrategyWithParams(lp)
+	assert.Error(t, err)
+	vp := DefaultLosscutValueParams()
+	vp.Variant = "v9"
+	_, err = NewLosscutValueStrategyWithParams(vp)
+	assert.Error(t, err)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 試すパラメータの値
+type ParamRange struct {
+	Name   string
+	Values []float64
+}
+
+// "name=from:to:step" か "name=v1,v2,..." を解釈する
+func ParseParamRange(s string) (*ParamRange, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return nil, fmt.Errorf("expected name=from:to:step or name=v1,v2,...: %s", s)
+	}
+	r := &ParamRange{Name: kv[0]}
+	if strings.Contains(kv[1], ":") {
+		fs := strings.Split(kv[1], ":")
+		if len(fs) != 3 {
+			return nil, fmt.Errorf("expected from:to:step for %s: %s", r.Name, kv[1])
+		}
+		vs := make([]float64, 3)
+		for i, f := range fs {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range for %s: %v", r.Name, err)
+			}
+			vs[i] = v
+		}
+		from, to, step := vs[0], vs[1], vs[2]
+		if step <= 0 || to < from {
+			return nil, fmt.Errorf("invalid range for %s: %s", r.Name, kv[1])
+		}
+		// 刻みの誤差で端が落ちないようにする
+		n := int(math.Floor((to-from)/step + 1e-9))
+		for i := 0; i <= n; i++ {
+			r.Values = append(r.Values, math.Round((from+float64(i)*step)*1e9)/1e9)
+		}
+		return r, nil
+	}
+	for _, f := range strings.Split(kv[1], ",") {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", r.Name, err)
+		}
+		r.Values = append(r.Values, v)
+	}
+	return r, nil
+}
+
+// すべての組み合わせ
+// 後の範囲ほど速く変わる
+func paramGrid(rs []*ParamRange) []map[string]float64 {
+	grid := []map[string]float64{{}}
+	for _, r := range rs {
+		next := []map[string]float64{}
+		for _, g := range grid {
+			for _, v := range r.Values {
+				p := map[string]float64{r.Name: v}
+				for k, gv := range g {
+					p[k] = gv
+				}
+				next = append(next, p)
+			}
+		}
+		grid = next
+	}
+	return grid
+}
+
+// 結果の表に出す統計 (この順に列を並べる)
+var sweepMetricNames = []string{
+	"cagr",
+	"max_drawdown",
+	"calmar_ratio",
+	"total_return",
+	"monthly_sharp_ratio",
+	"yearly_return_expect",
+	"yearly_return_stdev",
+	"yearly_sharp_ratio",
+	"losscuts",
+	"forced_margin_calls",
+}
+
+// ひとつの組み合わせの結果
+type SweepResult struct {
+	Strategy string               `json:"strategy"`
+	Params   map[string]float64   `json:"params"`
+	Metrics  map[string]jsonFloat `json:"metrics"`
+	Error    string               `json:"error,omitempty"`
+}
+
+func newSweepResult(strategy string, params map[string]float64, r *result) *SweepResult {
+	s := calcStat(r.initialDeposit, r.totalDeposit, r.valuations)
+	forced := 0
+	for _, e := range r.marginCalls {
+		if e.State == MarginCallForced {
+			forced++
+		}
+	}
+	return &SweepResult{
+		Strategy: strategy,
+		Params:   params,
+		Metrics: map[string]jsonFloat{
+			"cagr":                 s.CAGR,
+			"max_drawdown":         s.MaxDrawdown,
+			"calmar_ratio":         s.CalmarRatio,
+			"total_return":         s.TotalReturn,
+			"monthly_sharp_ratio":  s.MonthlySharpRatio,
+			"yearly_return_expect": s.YearlyReturnExpect,
+			"yearly_return_stdev":  s.YearlyReturnStdev,
+			"yearly_sharp_ratio":   s.YearlySharpRatio,
+			"losscuts":             jsonFloat(len(r.losscuts)),
+			"forced_margin_calls":  jsonFloat(forced),
+		},
+	}
+}
+
+// 戦略とパラメータを表す文字列。再開するときに実行済みかを調べるのに使う
+func sweepKey(strategy string, params map[string]float64) string {
+	ks := []string{}
+	for k := range params {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	kvs := []string{strategy}
+	for _, k := range ks {
+		kvs = append(kvs, fmt.Sprintf("%s=%v", k, params[k]))
+	}
+	return strings.Join(kvs, ",")
+}
+
+// パラメータの組み合わせごとのバックテスト
+type Sweep struct {
+	Config  *Config // normalize 済み。戦略のパラメータの基準になる
+	Index   []*DailyData
+	IV      []*DailyData
+	Ranges  []*ParamRange
+	Workers int
+	// 評価を始める日。前の日々は戦略の準備にだけ使う。ゼロ値なら最初から評価する
+	From time.Time
+
+	aux *auxData // 全組み合わせで共有する補助のデータ。nil なら Run で読み込む
+}
+
+// 実行する組み合わせ
+// パラメータは設定の値を範囲の値で上書きし、すべて埋めて検証する
+func (s *Sweep) jobs() ([]map[string]float64, error) {
+	e, err := LookupStrategy(s.Config.Strategy.Name)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, r := range s.Ranges {
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate range: %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	acc := []map[string]float64{}
+	for _, g := range paramGrid(s.Ranges) {
+		p := map[string]float64{}
+		for k, v := range s.Config.Strategy.Params {
+			p[k] = v
+		}
+		for k, v := range g {
+			p[k] = v
+		}
+		if p, err = e.normalizeParams(p); err != nil {
+			return nil, err
+		}
+		acc = append(acc, p)
+	}
+	return acc, nil
+}
+
+// done にない組み合わせを Workers 並列で実行し、終わった順に emit に渡す
+// emit は同時には呼ばれない
+// 組み合わせごとに設定を写し、口座と戦略は newBacktestWithData で作り直す。補助のデータは共有する
+func (s *Sweep) Run(done map[string]bool, emit func(*SweepResult) error) error {
+	jobs, err := s.jobs()
+	if err != nil {
+		return err
+	}
+	if s.aux == nil {
+		if s.aux, err = loadAuxData(s.Config); err != nil {
+			return err
+		}
+	}
+	name := s.Config.Strategy.Name
+	todo := make(chan map[string]float64)
+	results := make(chan *SweepResult)
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range todo {
+				results <- s.run(p)
+			}
+		}()
+	}
+	go func() {
+		for _, p := range jobs {
+			if !done[sweepKey(name, p)] {
+				todo <- p
+			}
+		}
+		close(todo)
+		wg.Wait()
+		close(results)
+	}()
+
+	var emitErr error
+	for r := range results {
+		// エラーの後も、ワーカーが止まるように読み切る
+		if emitErr == nil {
+			emitErr = emit(r)
+		}
+	}
+	return emitErr
+}
+
+func (s *Sweep) run(p map[string]float64) *SweepResult {
+	c := *s.Config
+	c.Strategy.Params = p
+	b, err := newBacktestWithData(&c, s.Index, s.IV, s.aux)
+	if err != nil {
+		return &SweepResult{Strategy: c.Strategy.Name, Params: p, Error: err.Error()}
+	}
+	r := b.run()
+	if !s.From.IsZero() {
+		if r = r.since(s.From); r == nil {
+			return &SweepResult{Strategy: c.Strategy.Name, Params: p, Error: fmt.Sprintf("no data since %s", formatDate(s.From))}
+		}
+	}
+	return newSweepResult(c.Strategy.Name, p, r)
+}
+
+// 途中経過のファイルの中身
+// 1行目はどのスイープのものかを表すヘッダ、2行目から1行に1つの結果の JSON
+type sweepProgress struct {
+	Fingerprint string         `json:"fingerprint"`
+	Results     []*SweepResult `json:"-"`
+}
+
+// 途中経過のファイルを読む
+// ファイルがなければ空。ヘッダがなければ Fingerprint は空
+func readSweepProgress(path string) (*sweepProgress, error) {
+	p := &sweepProgress{Results: []*SweepResult{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var broken error
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		if broken != nil {
+			return nil, broken
+		}
+		if line == 1 {
+			h := &sweepProgress{}
+			if err := json.Unmarshal(sc.Bytes(), h); err == nil && h.Fingerprint != "" {
+				p.Fingerprint = h.Fingerprint
+				continue
+			}
+		}
+		r := &SweepResult{}
+		if err := json.Unmarshal(sc.Bytes(), r); err != nil {
+			// 中断で最後の行が途中までしか書かれていなければ、その行はやり直す
+			broken = fmt.Errorf("%s:%d: %v", path, line, err)
+			continue
+		}
+		p.Results = append(p.Results, r)
+	}
+	return p, sc.Err()
+}
+
+// 設定、戦略、範囲とデータの期間を表す識別子
+// 再開するとき、途中経過が同じスイープのものかを確かめるのに使う
+func (s *Sweep) fingerprint() (string, error) {
+	from, to := "", ""
+	if len(s.Index) != 0 {
+		from, to = formatDate(s.Index[0].date), formatDate(s.Index[len(s.Index)-1].date)
+	}
+	b, err := json.Marshal(struct {
+		Config *Config       `json:"config"`
+		Ranges []*ParamRange `json:"ranges"`
+		From   string        `json:"from"`
+		To     string        `json:"to"`
+		Days   int           `json:"days"`
+	}{s.Config, s.Ranges, from, to, len(s.Index)})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 途中経過から再開する
+// 違うスイープの途中経過ならエラー。今の組み合わせにない結果は捨て、エラーだった組み合わせはやり直す
+// 残した結果と、実行済みの組み合わせを返す
+func (s *Sweep) resume(p *sweepProgress) ([]*SweepResult, map[string]bool, error) {
+	fp, err := s.fingerprint()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(p.Results) != 0 && p.Fingerprint != fp {
+		return nil, nil, fmt.Errorf("progress was written by a sweep with a different config, strategy or ranges")
+	}
+	jobs, err := s.jobs()
+	if err != nil {
+		return nil, nil, err
+	}
+	grid := map[string]bool{}
+	for _, j := range jobs {
+		grid[sweepKey(s.Config.Strategy.Name, j)] = true
+	}
+	kept := []*SweepResult{}
+	done := map[string]bool{}
+	for _, r := range p.Results {
+		k := sweepKey(r.Strategy, r.Params)
+		if !grid[k] || done[k] || r.Error != "" {
+			continue
+		}
+		kept = append(kept, r)
+		done[k] = true
+	}
+	return kept, done, nil
+}
+
+// 結果を統計 key の大きい順 (asc なら小さい順) に並べる
+// 値が NaN のものとエラーのものは最後
+func sortSweepResults(rs []*SweepResult, key string, asc bool) error {
+	valid := false
+	for _, n := range sweepMetricNames {
+		valid = valid || n == key
+	}
+	if !valid {
+		return fmt.Errorf("unknown sort key: %s (available: %s)", key, strings.Join(sweepMetricNames, ", "))
+	}
+	value := func(r *SweepResult) float64 {
+		v, ok := r.Metrics[key]
+		if !ok {
+			return math.NaN()
+		}
+		return float64(v)
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		vi, vj := value(rs[i]), value(rs[j])
+		switch {
+		case math.IsNaN(vj):
+			return !math.IsNaN(vi)
+		case math.IsNaN(vi):
+			return false
+		case asc:
+			return vi < vj
+		}
+		return vi > vj
+	})
+	return nil
+}
+
+// 結果の表を書き出す
+func writeSweepResults(w io.Writer, format string, rs []*SweepResult) error {
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		if rs == nil {
+			rs = []*SweepResult{}
+		}
+		return e.Encode(rs)
+	case "csv":
+		return writeSweepCSV(w, rs)
+	}
+	return fmt.Errorf("unknown format: %s", format)
+}
+
+// 列は strategy、パラメータ (名前順)、統計、error
+func writeSweepCSV(w io.Writer, rs []*SweepResult) error {
+	names := map[string]bool{}
+	for _, r := range rs {
+		for k := range r.Params {
+			names[k] = true
+		}
+	}
+	params := []string{}
+	for k := range names {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+
+	cw := csv.NewWriter(w)
+	header := append(append([]string{"strategy"}, params...), sweepMetricNames...)
+	if err := cw.Write(append(header, "error")); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		row := []string{r.Strategy}
+		for _, k := range params {
+			row = append(row, formatCSVFloat(r.Params[k]))
+		}
+		for _, k := range sweepMetricNames {
+			v, ok := r.Metrics[k]
+			if !ok {
+				v = jsonFloat(math.NaN())
+			}
+			row = append(row, formatCSVFloat(float64(v)))
+		}
+		if err := cw.Write(append(row, r.Error)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// NaN は空にする
+func formatCSVFloat(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParamRange(t *testing.T) {
+	r, err := ParseParamRange("slope=1.2:1.6:0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "slope", r.Name)
+	assert.Equal(t, []float64{1.2, 1.4, 1.6}, r.Values)
+
+	r, err = ParseParamRange("iv_ma=10,20,40")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{10, 20, 40}, r.Values)
+
+	for _, s := range []string{"slope", "slope=1:2", "slope=2:1:1", "slope=1:2:0", "slope=a"} {
+		_, err = ParseParamRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParamGrid(t *testing.T) {
+	g := paramGrid([]*ParamRange{{Name: "a", Values: []float64{1, 2}}, {Name: "b", Values: []float64{3, 4, 5}}})
+	assert.Equal(t, 6, len(g))
+	assert.Equal(t, map[string]float64{"a": 1, "b": 3}, g[0])
+	assert.Equal(t, map[string]float64{"a": 1, "b": 4}, g[1])
+	assert.Equal(t, map[string]float64{"a": 2, "b": 5}, g[5])
+}
+
+func TestSortSweepResults(t *testing.T) {
+	rs := []*SweepResult{
+		{Params: map[string]float64{"a": 1}, Metrics: map[string]jsonFloat{"cagr": 1.02}},
+		{Params: map[string]float64{"a": 2}, Error: "failed"},
+		{Params: map[string]float64{"a": 3}, Metrics: map[string]jsonFloat{"cagr": jsonFloat(math.NaN())}},
+		{Params: map[string]float64{"a": 4}, Metrics: map[string]jsonFloat{"cagr": 1.05}},
+	}
+	assert.NoError(t, sortSweepResults(rs, "cagr", false))
+	assert.Equal(t, 4.0, rs[0].Params["a"])
+	assert.Equal(t, 1.0, rs[1].Params["a"])
+	assert.NoError(t, sortSweepResults(rs, "cagr", true))
+	assert.Equal(t, 1.0, rs[0].Params["a"])
+	assert.Error(t, sortSweepResults(rs, "foo", false))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, writeSweepCSV(buf, rs[:2]))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "strategy,a,"+strings.Join(sweepMetricNames, ",")+",error", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], ",1,1.02,"))
+}
+
+func TestReadSweepProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sweep")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.csv.progress")
+
+	p, err := readSweepProgress(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(p.Results))
+
+	// 中断で途中まで書かれた最後の行は捨てる
+	body := `{"fingerprint":"abc"}` + "\n" + `{"strategy":"s","params":{"a":1},"metrics":{"cagr":1.1,"calmar_ratio":null}}` + "\n" + `{"strategy":"s","par`
+	assert.NoError(t, ioutil.WriteFile(path, []byte(body), 0644))
+	p, err = readSweepProgress(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", p.Fingerprint)
+	assert.Equal(t, 1, len(p.Results))
+	assert.Equal(t, "s,a=1", sweepKey(p.Results[0].Strategy, p.Results[0].Params))
+	assert.True(t, math.IsNaN(float64(p.Results[0].Metrics["calmar_ratio"])))
+
+	// 途中の行が壊れていればエラー
+	assert.NoError(t, ioutil.WriteFile(path, []byte("{\n"+body), 0644))
+	_, err = readSweepProgress(path)
+	assert.Error(t, err)
+}
+
+func TestSweepSharesAuxData(t *testing.T) {
+	index, iv := syntheticData(100)
+	c := syntheticConfig(t)
+	c.Financing.RateCSV = "missing.csv"
+	s := &Sweep{
+		Config: c,
+		Index:  index,
+		IV:     iv,
+		Ranges: []*ParamRange{{Name: "base", Values: []float64{6, 8}}},
+		aux: &auxData{
+			rate: &RateSeries{dates: []time.Time{date("2020-01-01")}, rates: []float64{0.01}},
+		},
+	}
+	// 読み込み済みのデータを使い、組み合わせごとにCSVを読み直さない
+	n := 0
+	assert.NoError(t, s.Run(nil, func(r *SweepResult) error {
+		assert.Equal(t, "", r.Error)
+		n++
+		return nil
+	}))
+	assert.Equal(t, 2, n)
+}
+
+func TestSweepResume(t *testing.T) {
+	index, iv := syntheticData(100)
+	s := &Sweep{
+		Config: syntheticConfig(t),
+		Index:  index,
+		IV:     iv,
+		Ranges: []*ParamRange{{Name: "base", Values: []float64{6, 8}}},
+	}
+	fp, err := s.fingerprint()
+	assert.NoError(t, err)
+	params := func(base float64) map[string]float64 {
+		return map[string]float64{"base": base, "index_ma": 40, "iv_ma": 20, "slope": 1.6}
+	}
+	name := s.Config.Strategy.Name
+	p := &sweepProgress{Fingerprint: fp, Results: []*SweepResult{
+		{Strategy: name, Params: params(6)},
+		// エラーだった組み合わせはやり直す
+		{Strategy: name, Params: params(8), Error: "failed"},
+		// 今の範囲にない組み合わせは捨てる
+		{Strategy: name, Params: params(10)},
+	}}
+	kept, done, err := s.resume(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(kept))
+	assert.Equal(t, map[string]bool{sweepKey(name, params(6)): true}, done)
+
+	// 範囲が違えば別のスイープ
+	s.Ranges = []*ParamRange{{Name: "base", Values: []float64{6, 8, 10}}}
+	_, _, err = s.resume(p)
+	assert.Error(t, err)
+	// ヘッダのない古い途中経過も再開しない
+	p.Fingerprint = ""
+	_, _, err = s.resume(p)
+	assert.Error(t, err)
+}
+
+// 波打つ株価指数とIVの日足
+func syntheticData(days int) (index, iv []*DailyData) {
+	d := date("2020-01-01")
+	for i := 0; i < days; i++ {
+		p := 1000 + 100*math.Sin(float64(i)/20)
+		v := 20 + 5*math.Cos(float64(i)/15)
+		index = append(index, &DailyData{date: d, open: p, high: p + 5, low: p - 5, close: p})
+		iv = append(iv, &DailyData{date: d, open: v, high: v, low: v, close: v})
+		d = d.Add(24 * time.Hour)
+	}
+	return index, iv
+}
+
+func syntheticConfig(t *testing.T) *Config {
+	c := DefaultConfig()
+	c.Data.Index, c.Data.IV = "index.csv", "iv.csv"
+	c.Deposit.Initial = 3000
+	assert.NoError(t, c.normalize())
+	return c
+}
+
+func TestSweepRun(t *testing.T) {
+	index, iv := syntheticData(300)
+	c := syntheticConfig(t)
+	s := &Sweep{
+		Config:  c,
+		Index:   index,
+		IV:      iv,
+		Ranges:  []*ParamRange{{Name: "base", Values: []float64{6, 8, 10}}, {Name: "iv_ma", Values: []float64{10, 20}}},
+		Workers: 3,
+	}
+
+	done := map[string]bool{sweepKey("leverage-ratio/v2", map[string]float64{"base": 6, "iv_ma": 10, "index_ma": 40, "slope": 1.6}): true}
+	rs := []*SweepResult{}
+	assert.NoError(t, s.Run(done, func(r *SweepResult) error {
+		rs = append(rs, r)
+		return nil
+	}))
+	assert.Equal(t, 5, len(rs))
+
+	// 並列に実行しても、単独で実行した結果と同じになる
+	for _, r := range rs {
+		assert.Equal(t, "", r.Error)
+		cc := *c
+		cc.Strategy.Params = r.Params
+		b, err := newBacktest(&cc, index, iv)
+		assert.NoError(t, err)
+		assert.Equal(t, newSweepResult(cc.Strategy.Name, r.Params, b.run()).Metrics["cagr"], r.Metrics["cagr"])
+	}
+
+	s.Ranges = append(s.Ranges, &ParamRange{Name: "base", Values: []float64{1}})
+	assert.Error(t, s.Run(nil, func(*SweepResult) error { return nil }))
+}
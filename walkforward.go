@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// ウォークフォワード分析
+// 期間 InSample でパラメータを最適化し、続く期間 OutOfSample にそのパラメータを使う
+// これを OutOfSample ずつずらしながら繰り返し、期間外の評価額をつなげる
+type WalkForward struct {
+	Sweep       *Sweep // Config, Index, IV は全期間。Config は normalize 済み
+	InSample    int    // 最適化する期間の長さ (Unit の数)
+	OutOfSample int    // 検証する期間の長さ (Unit の数)
+	Unit        Period
+	Objective   string // 最適化する統計 (sweepMetricNames のどれか)
+	Asc         bool   // 小さいほど良い統計かどうか
+}
+
+// ウォークフォワード分析の結果
+type WalkForwardReport struct {
+	Strategy    string             `json:"strategy"`
+	Objective   string             `json:"objective"`
+	Folds       []*walkForwardFold `json:"folds"`
+	Stat        *Stat              `json:"stat"` // つなげた期間外の評価額の統計
+	Diagnostics *overfitDiagnostic `json:"diagnostics"`
+}
+
+type walkForwardFold struct {
+	InSampleFrom    string             `json:"in_sample_from"`
+	InSampleTo      string             `json:"in_sample_to"`
+	OutOfSampleFrom string             `json:"out_of_sample_from"`
+	OutOfSampleTo   string             `json:"out_of_sample_to"`
+	Params          map[string]float64 `json:"params"`
+	InSample        *SweepResult       `json:"in_sample"`
+	OutOfSample     *SweepResult       `json:"out_of_sample"`
+	// 期間外で、選んだパラメータより悪かった組み合わせの割合 (1なら期間外でも最良)
+	OutOfSampleRank jsonFloat `json:"out_of_sample_rank"`
+}
+
+// 過剰最適化の診断
+type overfitDiagnostic struct {
+	InSampleMean    jsonFloat `json:"in_sample_mean"`     // 期間内の目的の統計の平均
+	OutOfSampleMean jsonFloat `json:"out_of_sample_mean"` // 期間外の目的の統計の平均
+	// cagr なら期間外と期間内の年率リターンの比、それ以外は平均の比
+	// 1に近いほど期間内の成績が期間外でも続いている
+	Efficiency jsonFloat `json:"efficiency"`
+	// 期間内で最良のパラメータが、期間外では半分より下だった期間の割合
+	// 0.5 に近いか超えていれば、期間内の最適化は期間外の成績に役立っていない
+	OverfitProbability jsonFloat `json:"overfit_probability"`
+}
+
+// ひとつの期間内と期間外
+type walkForwardWindow struct {
+	isFrom, isTo, oosFrom, oosTo time.Time // 両端を含む
+}
+
+// 期間を分ける
+// 最初の期間内は、戦略の準備に warm 営業日を残して始める
+// 期間外の最後はデータの終わりで切る
+func (w *WalkForward) windows(warm int) ([]*walkForwardWindow, error) {
+	if w.InSample < 1 || w.OutOfSample < 1 {
+		return nil, fmt.Errorf("in-sample and out-of-sample must be positive: %d, %d", w.InSample, w.OutOfSample)
+	}
+	index := w.Sweep.Index
+	if warm >= len(index) {
+		return nil, fmt.Errorf("data is shorter than the warm-up of %d days", warm)
+	}
+	first, last := index[warm].date, index[len(index)-1].date
+	acc := []*walkForwardWindow{}
+	for k := 0; ; k++ {
+		start := w.Unit.Add(first, k*w.OutOfSample)
+		oosFrom := w.Unit.Add(start, w.InSample)
+		if oosFrom.After(last) {
+			break
+		}
+		oosTo := w.Unit.Add(oosFrom, w.OutOfSample).AddDate(0, 0, -1)
+		if oosTo.After(last) {
+			oosTo = last
+		}
+		acc = append(acc, &walkForwardWindow{
+			isFrom:  start,
+			isTo:    oosFrom.AddDate(0, 0, -1),
+			oosFrom: oosFrom,
+			oosTo:   oosTo,
+		})
+	}
+	if len(acc) == 0 {
+		return nil, fmt.Errorf("data is shorter than warm-up + in-sample + out-of-sample")
+	}
+	return acc, nil
+}
+
+// 戦略の準備に必要な営業日数
+// すべての組み合わせのうち最も長いもの
+func (w *WalkForward) warmUp() (int, error) {
+	jobs, err := w.Sweep.jobs()
+	if err != nil {
+		return 0, err
+	}
+	warm := 0
+	for _, p := range jobs {
+		s, err := NewStrategyByName(w.Sweep.Config.Strategy.Name, p)
+		if err != nil {
+			return 0, err
+		}
+		if l, ok := s.(lookbackStrategy); ok {
+			warm = maxInt(warm, l.Lookback())
+		}
+	}
+	return warm, nil
+}
+
+// from ~ to を評価するスイープ
+// 戦略の準備のため、from より warm 営業日前のデータから始める
+func (w *WalkForward) sweepBetween(from, to time.Time, warm int) *Sweep {
+	index := w.Sweep.Index
+	start := 0
+	for start < len(index) && index[start].date.Before(from) {
+		start++
+	}
+	start = maxInt(start-warm, 0)
+	c := *w.Sweep.Config
+	c.Data.From = formatDate(index[start].date)
+	c.Data.To = formatDate(to)
+	c.Withdrawal.Start = ""
+	return &Sweep{
+		Config:  &c,
+		Index:   filterDailyData(index[start:], index[start].date, to),
+		IV:      filterDailyData(w.Sweep.IV[start:], index[start].date, to),
+		Ranges:  w.Sweep.Ranges,
+		Workers: w.Sweep.Workers,
+		From:    from,
+		aux:     w.Sweep.aux,
+	}
+}
+
+// すべての組み合わせを実行し、目的の統計の良い順に並べて返す
+func (w *WalkForward) rank(s *Sweep) ([]*SweepResult, error) {
+	rs := []*SweepResult{}
+	err := s.Run(nil, func(r *SweepResult) error {
+		rs = append(rs, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 実行順は並列のため決まらないので、同じ値なら組み合わせの順に並べる
+	sort.SliceStable(rs, func(i, j int) bool {
+		return sweepKey(rs[i].Strategy, rs[i].Params) < sweepKey(rs[j].Strategy, rs[j].Params)
+	})
+	if err := sortSweepResults(rs, w.Objective, w.Asc); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// 統計の値。なければ NaN
+func (w *WalkForward) value(r *SweepResult) float64 {
+	if r == nil || r.Error != "" {
+		return math.NaN()
+	}
+	return metricOf(r, w.Objective)
+}
+
+// ウォークフォワード分析を実行する
+// progress には期間ごとの結果を渡す。nil なら何もしない
+func (w *WalkForward) Run(progress func(*walkForwardFold)) (*WalkForwardReport, error) {
+	if err := sortSweepResults(nil, w.Objective, w.Asc); err != nil {
+		return nil, err
+	}
+	warm, err := w.warmUp()
+	if err != nil {
+		return nil, err
+	}
+	// 補助のデータはすべての期間で共有する
+	if w.Sweep.aux == nil {
+		if w.Sweep.aux, err = loadAuxData(w.Sweep.Config); err != nil {
+			return nil, err
+		}
+	}
+	ws, err := w.windows(warm)
+	if err != nil {
+		return nil, err
+	}
+	rep := &WalkForwardReport{
+		Strategy:  w.Sweep.Config.Strategy.Name,
+		Objective: w.Objective,
+		Folds:     []*walkForwardFold{},
+	}
+	stitched := []*dailyValuation{}
+	initial := w.Sweep.Config.Deposit.Initial
+	end := initial // つなげた評価額の最後
+	for _, win := range ws {
+		is := w.sweepBetween(win.isFrom, win.isTo, warm)
+		oos := w.sweepBetween(win.oosFrom, win.oosTo, warm)
+		isDays, oosDays := filterDailyData(is.Index, win.isFrom, win.isTo), filterDailyData(oos.Index, win.oosFrom, win.oosTo)
+		if len(isDays) == 0 || len(oosDays) == 0 {
+			continue
+		}
+		isResults, err := w.rank(is)
+		if err != nil {
+			return nil, err
+		}
+		best := isResults[0]
+		if math.IsNaN(w.value(best)) {
+			return nil, fmt.Errorf("no valid result in %s ~ %s", formatDate(win.isFrom), formatDate(win.isTo))
+		}
+		oosResults, err := w.rank(oos)
+		if err != nil {
+			return nil, err
+		}
+		key := sweepKey(best.Strategy, best.Params)
+		f := &walkForwardFold{
+			InSampleFrom:    formatDate(isDays[0].date),
+			InSampleTo:      formatDate(isDays[len(isDays)-1].date),
+			OutOfSampleFrom: formatDate(oosDays[0].date),
+			OutOfSampleTo:   formatDate(oosDays[len(oosDays)-1].date),
+			Params:          best.Params,
+			InSample:        best,
+			OutOfSampleRank: jsonFloat(math.NaN()),
+		}
+		for _, r := range oosResults {
+			if sweepKey(r.Strategy, r.Params) == key {
+				f.OutOfSample = r
+			}
+		}
+		if chosen := w.value(f.OutOfSample); !math.IsNaN(chosen) {
+			worse, others := 0, 0
+			for _, r := range oosResults {
+				v := w.value(r)
+				if r == f.OutOfSample || math.IsNaN(v) {
+					continue
+				}
+				others++
+				if w.better(chosen, v) {
+					worse++
+				}
+			}
+			if others != 0 {
+				f.OutOfSampleRank = jsonFloat(float64(worse) / float64(others))
+			}
+		}
+
+		// 選んだパラメータの期間外の日々のリターンを、前の期間の終わりの評価額からつなげる
+		c := *oos.Config
+		c.Strategy.Params = best.Params
+		b, err := newBacktestWithData(&c, oos.Index, oos.IV, w.Sweep.aux)
+		if err != nil {
+			return nil, err
+		}
+		r := b.run().since(win.oosFrom)
+		stitched = append(stitched, stitchReturns(r, end)...)
+		end = stitched[len(stitched)-1].valuation
+
+		rep.Folds = append(rep.Folds, f)
+		if progress != nil {
+			progress(f)
+		}
+	}
+	if len(stitched) == 0 {
+		return nil, fmt.Errorf("no fold has data")
+	}
+	rep.Stat = calcStat(initial, initial, stitched)
+	rep.Diagnostics = w.diagnose(rep.Folds)
+	return rep, nil
+}
+
+// 入出金を除いた日々のリターンで、評価額 from から始まる評価額の列を作る
+// 入出金はその日の評価額から差し引き、前日の評価額に対するリターンを求める
+func stitchReturns(r *result, from float64) []*dailyValuation {
+	acc := []*dailyValuation{}
+	prev, deposit, withdrawal := r.initialDeposit, r.initialDeposit, 0.0
+	for _, v := range r.valuations {
+		flow := (v.deposit - deposit) - (v.withdrawal - withdrawal)
+		if prev > 0 {
+			from *= (v.valuation - flow) / prev
+		} else {
+			from = 0
+		}
+		acc = append(acc, &dailyValuation{date: v.date, valuation: from})
+		prev, deposit, withdrawal = v.valuation, v.deposit, v.withdrawal
+	}
+	return acc
+}
+
+// a が b より良いか
+func (w *WalkForward) better(a, b float64) bool {
+	if w.Asc {
+		return a < b
+	}
+	return a > b
+}
+
+func (w *WalkForward) diagnose(fs []*walkForwardFold) *overfitDiagnostic {
+	iss, ooss := []float64{}, []float64{}
+	overfit, ranked := 0, 0
+	for _, f := range fs {
+		is, oos := w.value(f.InSample), w.value(f.OutOfSample)
+		if math.IsNaN(is) || math.IsNaN(oos) {
+			continue
+		}
+		iss = append(iss, is)
+		ooss = append(ooss, oos)
+		if r := float64(f.OutOfSampleRank); !math.IsNaN(r) {
+			ranked++
+			if r < 0.5 {
+				overfit++
+			}
+		}
+	}
+	d := &overfitDiagnostic{
+		InSampleMean:       jsonFloat(avg(iss)),
+		OutOfSampleMean:    jsonFloat(avg(ooss)),
+		OverfitProbability: jsonFloat(math.NaN()),
+	}
+	if w.Objective == "cagr" {
+		// CAGR は1倍を基準にした比なので、リターンどうしで比べる
+		d.Efficiency = jsonFloat((avg(ooss) - 1) / (avg(iss) - 1))
+	} else {
+		d.Efficiency = jsonFloat(avg(ooss) / avg(iss))
+	}
+	if ranked != 0 {
+		d.OverfitProbability = jsonFloat(float64(overfit) / float64(ranked))
+	}
+	return d
+}
+
+func printWalkForwardReport(w io.Writer, rep *WalkForwardReport) {
+	for _, f := range rep.Folds {
+		fmt.Fprintf(w, "%s ~ %s\t%s ~ %s\t%s\t%f\t%f\t%f\n",
+			f.InSampleFrom, f.InSampleTo, f.OutOfSampleFrom, f.OutOfSampleTo,
+			sweepKey(rep.Strategy, f.Params),
+			metricOf(f.InSample, rep.Objective), metricOf(f.OutOfSample, rep.Objective), f.OutOfSampleRank)
+	}
+	printStat(w, rep.Stat)
+	d := rep.Diagnostics
+	fmt.Fprintf(w, "objective: %s\n", rep.Objective)
+	fmt.Fprintf(w, "in-sample mean: %f\n", d.InSampleMean)
+	fmt.Fprintf(w, "out-of-sample mean: %f\n", d.OutOfSampleMean)
+	fmt.Fprintf(w, "walk-forward efficiency: %f\n", d.Efficiency)
+	fmt.Fprintf(w, "overfit probability: %f\n", d.OverfitProbability)
+}
+
+// 結果の統計 name の値。なければ NaN
+func metricOf(r *SweepResult, name string) float64 {
+	if r == nil {
+		return math.NaN()
+	}
+	if v, ok := r.Metrics[name]; ok {
+		return float64(v)
+	}
+	return math.NaN()
+}
+
+func printWalkForwardReportJSON(w io.Writer, c *Config, rep *WalkForwardReport) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(struct {
+		Config      *Config            `json:"config"`
+		WalkForward *WalkForwardReport `json:"walk_forward"`
+	}{c, rep})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkForwardWindows(t *testing.T) {
+	index, iv := syntheticData(300) // 2020-01-01 ~ 2020-10-26
+	w := &WalkForward{
+		Sweep:       &Sweep{Index: index, IV: iv},
+		InSample:    4,
+		OutOfSample: 2,
+		Unit:        Month,
+	}
+	ws, err := w.windows(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(ws))
+	assert.Equal(t, date("2020-01-01"), ws[0].isFrom)
+	assert.Equal(t, date("2020-04-30"), ws[0].isTo)
+	assert.Equal(t, date("2020-05-01"), ws[0].oosFrom)
+	assert.Equal(t, date("2020-06-30"), ws[0].oosTo)
+	assert.Equal(t, date("2020-03-01"), ws[1].isFrom)
+	// 最後の期間外はデータの終わりで切る
+	assert.Equal(t, date("2020-09-01"), ws[2].oosFrom)
+	assert.Equal(t, date("2020-10-26"), ws[2].oosTo)
+
+	// 戦略の準備の日々を残して始める
+	ws, err = w.windows(31)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(ws))
+	assert.Equal(t, date("2020-02-01"), ws[0].isFrom)
+	assert.Equal(t, date("2020-06-01"), ws[0].oosFrom)
+	_, err = w.windows(300)
+	assert.Error(t, err)
+
+	w.InSample = 12
+	_, err = w.windows(0)
+	assert.Error(t, err)
+}
+
+func TestWalkForwardRun(t *testing.T) {
+	index, iv := syntheticData(300)
+	c := syntheticConfig(t)
+	w := &WalkForward{
+		Sweep: &Sweep{
+			Config:  c,
+			Index:   index,
+			IV:      iv,
+			Ranges:  []*ParamRange{{Name: "base", Values: []float64{4, 7, 10}}},
+			Workers: 2,
+		},
+		InSample:    4,
+		OutOfSample: 2,
+		Unit:        Month,
+		Objective:   "cagr",
+	}
+	folds := 0
+	rep, err := w.Run(func(*walkForwardFold) { folds++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, folds)
+	assert.Equal(t, 3, len(rep.Folds))
+
+	for _, f := range rep.Folds {
+		// 期間内で最良のものを選ぶ
+		assert.Contains(t, []float64{4, 7, 10}, f.Params["base"])
+		assert.Equal(t, f.Params, f.OutOfSample.Params)
+		r := float64(f.OutOfSampleRank)
+		assert.True(t, r >= 0 && r <= 1)
+	}
+	// 最も長い移動平均 (index_ma = 40) の日数を準備に使い、期間内はその後から始める
+	assert.Equal(t, "2020-02-10", rep.Folds[0].InSampleFrom)
+	// 期間外だけをつなげる
+	assert.Equal(t, "2020-06-10", rep.Stat.Daily[0].Date)
+	assert.Equal(t, "2020-10-26", rep.Stat.Daily[len(rep.Stat.Daily)-1].Date)
+	assert.False(t, math.IsNaN(float64(rep.Diagnostics.InSampleMean)))
+
+	w.Objective = "foo"
+	_, err = w.Run(nil)
+	assert.Error(t, err)
+}
+
+func TestStitchReturns(t *testing.T) {
+	r := &result{
+		initialDeposit: 100,
+		valuations: []*dailyValuation{
+			{date: date("2020-01-01"), valuation: 110, deposit: 100},
+			// 入金した100はリターンに数えない
+			{date: date("2020-01-02"), valuation: 220, deposit: 200},
+			// 出金した20もリターンに数えない
+			{date: date("2020-01-03"), valuation: 200, deposit: 200, withdrawal: 20},
+		},
+	}
+	vs := stitchReturns(r, 1000)
+	assert.Equal(t, 3, len(vs))
+	assert.InDelta(t, 1100, vs[0].valuation, 1e-9)
+	assert.InDelta(t, 1200, vs[1].valuation, 1e-9)
+	assert.InDelta(t, 1200, vs[2].valuation, 1e-9)
+	assert.Equal(t, date("2020-01-03"), vs[2].date)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// 出金額の決め方
+type WithdrawalRule string
+
+const (
+	// 毎回決まった額
+	WithdrawFixed WithdrawalRule = "fixed"
+	// 毎回、評価額に対して決まった割合
+	WithdrawPercent WithdrawalRule = "percent"
+	// 決まった額だが、高値から一定以上下落している間は減らす
+	WithdrawGuardrail WithdrawalRule = "guardrail"
+)
+
+func ParseWithdrawalRule(s string) (WithdrawalRule, error) {
+	switch r := WithdrawalRule(s); r {
+	case WithdrawFixed, WithdrawPercent, WithdrawGuardrail:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown withdrawal rule: %s", s)
+}
+
+// 出金計画
+// 開始日より後の、各期間 (月または年) の最初の営業日に出金する
+type WithdrawalPlan struct {
+	// 計画の開始日。ゼロ値なら最初の営業日
+	Start     time.Time
+	Rule      WithdrawalRule
+	Frequency Period
+	// fixed, guardrail での1回あたりの出金額
+	Amount float64
+	// percent での評価額に対する割合
+	Rate float64
+	// guardrail で、高値からの下落率がこれ以上なら出金額を減らす (0.2 なら20%下落)
+	DrawdownThreshold float64
+	// guardrail で出金額を減らす割合 (0.3 なら3割減らす)
+	Cut float64
+	// 余力が足りないとき、ポジションを決済して出金するかどうか
+	AllowClose bool
+}
+
+// prev (前の営業日) から cur (今回の営業日) の間に出金日があるかどうか
+func (p *WithdrawalPlan) IsDue(prev, cur time.Time) bool {
+	if !cur.After(p.Start) {
+		return false
+	}
+	return !p.Frequency.Same(prev, cur)
+}
+
+// 出金したい額
+// valuation は現在の口座の評価額、high はこれまでの評価額の最高値
+func (p *WithdrawalPlan) Request(valuation, high float64) float64 {
+	switch p.Rule {
+	case WithdrawFixed:
+		return p.Amount
+	case WithdrawPercent:
+		if valuation <= 0 {
+			return 0
+		}
+		return valuation * p.Rate
+	case WithdrawGuardrail:
+		if high > 0 && valuation <= high*(1-p.DrawdownThreshold) {
+			return p.Amount * (1 - p.Cut)
+		}
+		return p.Amount
+	}
+	panic("unknown withdrawal rule: " + string(p.Rule))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithdrawalPlanIsDue(t *testing.T) {
+	p := &WithdrawalPlan{
+		Start:     date("2020-01-15"),
+		Rule:      WithdrawFixed,
+		Frequency: Month,
+	}
+
+	assert.False(t, p.IsDue(date("2020-01-14"), date("2020-01-15")))
+	assert.False(t, p.IsDue(date("2020-01-15"), date("2020-01-16")))
+	assert.True(t, p.IsDue(date("2020-01-31"), date("2020-02-03")))
+	assert.False(t, p.IsDue(date("2020-02-03"), date("2020-02-04")))
+
+	p.Frequency = Year
+	assert.False(t, p.IsDue(date("2020-01-31"), date("2020-02-03")))
+	assert.True(t, p.IsDue(date("2020-12-31"), date("2021-01-04")))
+}
+
+func TestWithdrawalPlanRequest(t *testing.T) {
+	p := &WithdrawalPlan{
+		Rule:   WithdrawFixed,
+		Amount: 100,
+		Rate:   0.04,
+	}
+	assert.Equal(t, 100.0, p.Request(1000, 2000))
+
+	p.Rule = WithdrawPercent
+	assert.Equal(t, 40.0, p.Request(1000, 2000))
+	assert.Equal(t, 0.0, p.Request(-10, 2000))
+
+	p.Rule = WithdrawGuardrail
+	p.DrawdownThreshold = 0.2
+	p.Cut = 0.5
+	assert.Equal(t, 100.0, p.Request(1700, 2000))
+	assert.Equal(t, 50.0, p.Request(1600, 2000))
+	assert.Equal(t, 50.0, p.Request(1000, 2000))
+}